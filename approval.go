@@ -0,0 +1,287 @@
+package playback
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const pendingSuffix = ".pending"
+
+// PendingFilename returns the name of the side file keeping records awaiting approval.
+func PendingFilename(filename string) string {
+	compressed := isCompressedName(filename)
+	filename = strings.TrimSuffix(filename, compressedSuffix)
+
+	ext := filepath.Ext(filename)
+	filename = strings.TrimSuffix(filename, ext) + pendingSuffix + ext
+	if compressed {
+		filename += compressedSuffix
+	}
+
+	return filename
+}
+
+func (c *Cassette) Approval() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.approval
+}
+
+// SetApproval makes records recorded in ModePlaybackOrRecord and ModePlaybackSuccessOrRecord
+// pending until they are approved. Playback treats pending records as misses.
+func (c *Cassette) SetApproval(approval bool) *Cassette {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.approval = approval
+
+	return c
+}
+
+func (c *Cassette) pendingHeader() CassetteHeader {
+	header := newCassetteHeader()
+	header.Canonical = c.header.Canonical
+
+	return header
+}
+
+func (c *Cassette) needsApproval() bool {
+	return c.approval && (c.mode == ModePlaybackOrRecord || c.mode == ModePlaybackSuccessOrRecord)
+}
+
+// addPending keeps the record awaiting approval.
+// A call recorded again replaces its pending record if the response changed and is dropped otherwise.
+func (c *Cassette) addPending(rec *record) error {
+	rec.Pending = true
+
+	if pending := c.findPending(rec); pending != nil {
+		if sameResponse(pending, rec) {
+			return nil
+		}
+
+		rec.ID = pending.ID
+		*pending = *rec
+		return c.rewritePending()
+	}
+
+	c.addPendingRecord(rec)
+
+	if c.format == FormatJSON {
+		return c.rewritePending()
+	}
+
+	stored, err := c.stored([]*record{rec})
+	if err != nil {
+		return err
+	}
+
+	return c.writePending(string(marshalAppendable(c.format, stored, c.header.Canonical)))
+}
+
+func (c *Cassette) writePending(content string) error {
+	if c.pendingWriter == nil {
+		filename := c.filename()
+		if filename == "" {
+			return nil
+		}
+
+		f, err := os.OpenFile(PendingFilename(filename), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		c.pendingWriter = newFileWriter(f)
+
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if info.Size() == 0 {
+			content = string(marshalFileStart(c.format, c.pendingHeader())) + content
+		}
+	}
+
+	_, err := io.WriteString(c.pendingWriter, content)
+	return err
+}
+
+func (c *Cassette) loadPending(filename string) error {
+	dump, err := ioutil.ReadFile(PendingFilename(filename))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	_, _, records, err := unmarshalCassetteFile(dump)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, rec := range records {
+		rec.cassette = c
+		rec.Pending = true
+
+		if pending := c.findPending(rec); pending != nil {
+			rec.ID = pending.ID
+			*pending = *rec
+			continue
+		}
+
+		c.addPendingRecord(rec)
+	}
+
+	return nil
+}
+
+// addPendingRecord appends the record to the pending ones.
+// A record of a track is never overwritten: a taken ID is replaced by a new one.
+func (c *Cassette) addPendingRecord(rec *record) {
+	if c.recordByID[rec.ID] != nil {
+		rec.ID = 0
+	}
+
+	c.setID(rec)
+	c.recordByID[rec.ID] = rec
+	c.pending = append(c.pending, rec)
+}
+
+// findPending returns the pending record of the same call as rec.
+func (c *Cassette) findPending(rec *record) *record {
+	for _, pending := range c.pending {
+		if pending.Kind == rec.Kind && pending.Key == rec.Key && pending.Request == rec.Request {
+			return pending
+		}
+	}
+
+	return nil
+}
+
+func sameResponse(a, b *record) bool {
+	return a.ResponseMeta == b.ResponseMeta &&
+		a.Response == b.Response &&
+		a.ResponseBody == b.ResponseBody &&
+		yamlMarshalString(a.Err) == yamlMarshalString(b.Err)
+}
+
+// PendingRecords returns the records awaiting approval.
+func (c *Cassette) PendingRecords() []RecordRef {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	refs := make([]RecordRef, 0, len(c.pending))
+	for _, rec := range c.pending {
+		refs = append(refs, newRecordRef(rec))
+	}
+
+	return refs
+}
+
+func (c *Cassette) MarshalPendingToYAML() []byte {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if len(c.pending) == 0 {
+		return nil
+	}
+
+	return marshalCassetteFile(FormatYAML, c.pendingHeader(), c.resolved(c.pending))
+}
+
+// Approve moves the pending records with the given IDs, or all of them if none given,
+// into the cassette and rewrites the cassette files.
+func (c *Cassette) Approve(ids ...uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	approved := c.takePending(ids)
+	for _, rec := range approved {
+		rec.Pending = false
+		delete(c.recordByID, rec.ID)
+		c.add(rec)
+	}
+
+	if len(approved) > 0 {
+		var err error
+		if c.writer != nil && !c.writer.ReadOnly() {
+			err = c.writeRecords(approved)
+		} else {
+			err = c.rewrite()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.rewritePending()
+}
+
+// Reject drops the pending records with the given IDs, or all of them if none given.
+func (c *Cassette) Reject(ids ...uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, rec := range c.takePending(ids) {
+		delete(c.recordByID, rec.ID)
+	}
+
+	return c.rewritePending()
+}
+
+func (c *Cassette) takePending(ids []uint64) []*record {
+	if len(ids) == 0 {
+		taken := c.pending
+		c.pending = nil
+		return taken
+	}
+
+	selected := make(map[uint64]bool, len(ids))
+	for _, id := range ids {
+		selected[id] = true
+	}
+
+	var taken, rest []*record
+	for _, rec := range c.pending {
+		if selected[rec.ID] {
+			taken = append(taken, rec)
+		} else {
+			rest = append(rest, rec)
+		}
+	}
+	c.pending = rest
+
+	return taken
+}
+
+func (c *Cassette) rewritePending() error {
+	if c.pendingWriter != nil {
+		c.pendingWriter.Close()
+		c.pendingWriter = nil
+	}
+
+	if c.filename() == "" {
+		return nil
+	}
+
+	filename := PendingFilename(c.filename())
+	if len(c.pending) == 0 {
+		err := os.Remove(filename)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	stored, err := c.stored(c.pending)
+	if err != nil {
+		return err
+	}
+
+	return writeCassetteFile(filename, marshalCassetteFile(c.format, c.pendingHeader(), stored))
+}
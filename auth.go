@@ -0,0 +1,225 @@
+package playback
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+var (
+	// ErrUnauthenticated is returned by authorizers when the caller presents no known credentials.
+	ErrUnauthenticated = errors.New("Caller is not authenticated")
+	// ErrForbidden is returned by authorizers when the caller lacks the permission.
+	ErrForbidden = errors.New("Caller is not allowed to access cassettes")
+)
+
+// Permission is a kind of access to cassettes.
+type Permission string
+
+const (
+	// PermissionRead allows to get and list cassettes and to replay them by the x-playback-* headers.
+	PermissionRead Permission = "read"
+	// PermissionRecord allows to add cassettes and to switch requests into record mode.
+	PermissionRecord Permission = "record"
+	// PermissionAdmin allows to delete cassettes, review pending records and reload the library.
+	// It grants the other permissions too.
+	PermissionAdmin Permission = "admin"
+)
+
+// Credentials are what the caller presents to the service endpoints and the middlewares:
+// the HTTP headers or the gRPC metadata and the TLS connection state.
+type Credentials struct {
+	Header http.Header
+	TLS    *tls.ConnectionState
+}
+
+// Authorizer decides whether the caller has the permission.
+// It returns ErrUnauthenticated or ErrForbidden, or another error, to deny the access.
+type Authorizer interface {
+	Authorize(creds Credentials, permission Permission) error
+}
+
+type AuthorizerFunc func(creds Credentials, permission Permission) error
+
+func (f AuthorizerFunc) Authorize(creds Credentials, permission Permission) error {
+	return f(creds, permission)
+}
+
+func grants(granted []Permission, permission Permission) bool {
+	for _, grant := range granted {
+		if grant == permission || grant == PermissionAdmin {
+			return true
+		}
+	}
+
+	return false
+}
+
+// BearerAuthorizer grants permissions by the token of the Authorization: Bearer header.
+type BearerAuthorizer struct {
+	tokens map[string][]Permission
+}
+
+func NewBearerAuthorizer(tokens map[string][]Permission) *BearerAuthorizer {
+	return &BearerAuthorizer{tokens: tokens}
+}
+
+func (a *BearerAuthorizer) Authorize(creds Credentials, permission Permission) error {
+	token := strings.TrimSpace(creds.Header.Get("Authorization"))
+	if len(token) < len("Bearer ") || !strings.EqualFold(token[:len("Bearer ")], "Bearer ") {
+		return ErrUnauthenticated
+	}
+	token = strings.TrimSpace(token[len("Bearer "):])
+
+	var granted []Permission
+	found := false
+	for known, permissions := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(known), []byte(token)) == 1 {
+			granted, found = permissions, true
+		}
+	}
+	if !found {
+		return ErrUnauthenticated
+	}
+	if !grants(granted, permission) {
+		return ErrForbidden
+	}
+
+	return nil
+}
+
+// ClientCertAuthorizer grants permissions by the common name of the verified TLS client certificate.
+// The server should verify client certificates, e.g. with tls.RequireAndVerifyClientCert.
+type ClientCertAuthorizer struct {
+	names map[string][]Permission
+}
+
+func NewClientCertAuthorizer(names map[string][]Permission) *ClientCertAuthorizer {
+	return &ClientCertAuthorizer{names: names}
+}
+
+func (a *ClientCertAuthorizer) Authorize(creds Credentials, permission Permission) error {
+	if creds.TLS == nil || len(creds.TLS.VerifiedChains) == 0 || len(creds.TLS.VerifiedChains[0]) == 0 {
+		return ErrUnauthenticated
+	}
+
+	granted, ok := a.names[creds.TLS.VerifiedChains[0][0].Subject.CommonName]
+	if !ok {
+		return ErrUnauthenticated
+	}
+	if !grants(granted, permission) {
+		return ErrForbidden
+	}
+
+	return nil
+}
+
+// AnyAuthorizer allows the access if one of the authorizers allows it.
+// The most specific denial is returned otherwise.
+func AnyAuthorizer(authorizers ...Authorizer) Authorizer {
+	return AuthorizerFunc(func(creds Credentials, permission Permission) error {
+		err := ErrUnauthenticated
+		for _, authorizer := range authorizers {
+			denied := authorizer.Authorize(creds, permission)
+			if denied == nil {
+				return nil
+			}
+			if denied != ErrUnauthenticated {
+				err = denied
+			}
+		}
+
+		return err
+	})
+}
+
+// SetAuthorizer protects the service endpoints and the x-playback-* headers.
+// Everything is allowed without an authorizer.
+func (p *Playback) SetAuthorizer(authorizer Authorizer) *Playback {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.authorizer = authorizer
+
+	return p
+}
+
+func (p *Playback) Authorizer() Authorizer {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.authorizer
+}
+
+func (p *Playback) authorize(creds Credentials, permission Permission) error {
+	authorizer := p.Authorizer()
+	if authorizer == nil {
+		return nil
+	}
+
+	return authorizer.Authorize(creds, permission)
+}
+
+// headersPermissions returns the permissions needed to honor the x-playback-* headers.
+// Recording needs the read permission too if the headers select an existing cassette,
+// because it is replayed instead of recorded.
+func (p *Playback) headersPermissions(cassetteID, name, mode, pathType, pathName string) []Permission {
+	if Mode(mode) == ModeRecord {
+		if p.selectsCassette(cassetteID, name, pathType, pathName) {
+			return []Permission{PermissionRecord, PermissionRead}
+		}
+		return []Permission{PermissionRecord}
+	}
+	if cassetteID != "" || name != "" || pathType != "" || pathName != "" {
+		return []Permission{PermissionRead}
+	}
+
+	return nil
+}
+
+func httpCredentials(req *http.Request) Credentials {
+	return Credentials{Header: req.Header, TLS: req.TLS}
+}
+
+func grpcCredentials(ctx context.Context) Credentials {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	creds := Credentials{Header: make(http.Header, len(md))}
+	for key, values := range md {
+		creds.Header[textproto.CanonicalMIMEHeaderKey(key)] = values
+	}
+
+	if client, ok := peer.FromContext(ctx); ok {
+		if info, ok := client.AuthInfo.(credentials.TLSInfo); ok {
+			creds.TLS = &info.State
+		}
+	}
+
+	return creds
+}
+
+// authorizedHandler answers 401 or 403 to callers without the permission.
+func (p *Playback) authorizedHandler(permission Permission, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		err := p.authorize(httpCredentials(req), permission)
+		if err == ErrUnauthenticated {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		handler(w, req)
+	}
+}
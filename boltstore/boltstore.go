@@ -0,0 +1,90 @@
+// Package boltstore keeps playback cassettes in a bbolt database file.
+package boltstore
+
+import (
+	"time"
+
+	"github.com/wtertius/playback"
+	bolt "go.etcd.io/bbolt"
+)
+
+var defaultBucket = []byte("cassettes")
+
+// Store is a playback.Store keeping cassettes in a bucket of a bbolt database.
+type Store struct {
+	db     *bolt.DB
+	bucket []byte
+}
+
+// Open opens or creates the database file.
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	return New(db, defaultBucket)
+}
+
+// New keeps cassettes in the bucket of an opened database.
+func New(db *bolt.DB, bucket []byte) (*Store, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{db: db, bucket: bucket}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func (s *Store) Save(name string, dump []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.bucket).Put([]byte(name), dump)
+	})
+}
+
+func (s *Store) Load(name string) ([]byte, error) {
+	var dump []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(s.bucket).Get([]byte(name))
+		if value == nil {
+			return playback.ErrStoreNotFound
+		}
+
+		dump = append([]byte(nil), value...)
+		return nil
+	})
+
+	return dump, err
+}
+
+func (s *Store) List() ([]string, error) {
+	var names []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.bucket).ForEach(func(name, _ []byte) error {
+			names = append(names, string(name))
+			return nil
+		})
+	})
+
+	return names, err
+}
+
+func (s *Store) Delete(name string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(s.bucket)
+		if bucket.Get([]byte(name)) == nil {
+			return playback.ErrStoreNotFound
+		}
+
+		return bucket.Delete([]byte(name))
+	})
+}
+
+var _ playback.Store = (*Store)(nil)
@@ -0,0 +1,189 @@
+package playback
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+const httpHeaderEnd = "\r\n\r\n"
+
+// httpMessage is an HTTP dump split into fields to keep cassette diffs readable.
+type httpMessage struct {
+	Method  string   `yaml:"method,omitempty" json:"method,omitempty"`
+	URL     string   `yaml:"url,omitempty" json:"url,omitempty"`
+	Proto   string   `yaml:"proto,omitempty" json:"proto,omitempty"`
+	Status  string   `yaml:"status,omitempty" json:"status,omitempty"`
+	Headers []string `yaml:"headers,omitempty" json:"headers,omitempty"`
+	Body    string   `yaml:"body,omitempty" json:"body,omitempty"`
+	JSON    bool     `yaml:"json,omitempty" json:"json,omitempty"`
+}
+
+// splitHTTPMessage splits an HTTP dump made by httputil.
+// It returns nil if the dump can't be restored from the fields exactly.
+func splitHTTPMessage(dump string) *httpMessage {
+	end := strings.Index(dump, httpHeaderEnd)
+	if end < 0 {
+		return nil
+	}
+
+	lines := strings.Split(dump[:end], "\r\n")
+	start := strings.SplitN(lines[0], " ", 3)
+	if len(start) < 2 {
+		return nil
+	}
+
+	msg := &httpMessage{
+		Headers: lines[1:],
+		Body:    dump[end+len(httpHeaderEnd):],
+	}
+	if strings.HasPrefix(start[0], "HTTP/") {
+		msg.Proto, msg.Status = start[0], strings.Join(start[1:], " ")
+	} else if len(start) == 3 {
+		msg.Method, msg.URL, msg.Proto = start[0], start[1], start[2]
+	} else {
+		return nil
+	}
+
+	if pretty, ok := prettyJSON(msg.Body); ok {
+		msg.Body, msg.JSON = pretty, true
+	}
+
+	if msg.String() != dump {
+		return nil
+	}
+
+	return msg
+}
+
+func (m *httpMessage) String() string {
+	var buf strings.Builder
+	if m.Method != "" {
+		buf.WriteString(m.Method + " " + m.URL + " " + m.Proto)
+	} else {
+		buf.WriteString(m.Proto + " " + m.Status)
+	}
+	for _, header := range m.Headers {
+		buf.WriteString("\r\n" + header)
+	}
+	buf.WriteString(httpHeaderEnd)
+
+	body := m.Body
+	if m.JSON {
+		body = compactJSON(body)
+	}
+	buf.WriteString(body)
+
+	return buf.String()
+}
+
+// prettyJSON indents a compact JSON body. Bodies that wouldn't be restored exactly are left as is.
+func prettyJSON(body string) (string, bool) {
+	if body == "" || !json.Valid([]byte(body)) {
+		return "", false
+	}
+
+	var buf bytes.Buffer
+	if json.Indent(&buf, []byte(body), "", "  ") != nil {
+		return "", false
+	}
+
+	pretty := buf.String() + "\n"
+	if compactJSON(pretty) != body {
+		return "", false
+	}
+
+	return pretty, true
+}
+
+func compactJSON(body string) string {
+	var buf bytes.Buffer
+	if json.Compact(&buf, []byte(body)) != nil {
+		return body
+	}
+
+	return buf.String()
+}
+
+func isHTTPKind(kind RecordKind) bool {
+	return kind == KindHTTP || kind == KindHTTPRequest
+}
+
+// canonical returns a copy of the record with HTTP dumps split into fields.
+func (r *record) canonical() *record {
+	if !isHTTPKind(r.Kind) {
+		return r
+	}
+
+	rec := *r
+	if msg := splitHTTPMessage(rec.Request); msg != nil {
+		rec.Request, rec.RequestHTTP = "", msg
+	}
+	if msg := splitHTTPMessage(rec.Response); msg != nil {
+		rec.Response, rec.ResponseHTTP = "", msg
+	}
+
+	return &rec
+}
+
+// joinHTTP restores HTTP dumps split by canonical.
+func (r *record) joinHTTP() {
+	if r.RequestHTTP != nil {
+		r.Request, r.RequestHTTP = r.RequestHTTP.String(), nil
+	}
+	if r.ResponseHTTP != nil {
+		r.Response, r.ResponseHTTP = r.ResponseHTTP.String(), nil
+	}
+}
+
+func joinHTTPMessages(records []*record) {
+	for _, rec := range records {
+		rec.joinHTTP()
+	}
+}
+
+// marshalRecords marshals records in ID order, in the canonical form if asked.
+func marshalRecords(records []*record, canonical bool) []byte {
+	if len(records) == 0 {
+		return nil
+	}
+
+	return yamlMarshal(sortRecords(records, canonical))
+}
+
+func sortRecords(records []*record, canonical bool) []*record {
+	sorted := make([]*record, 0, len(records))
+	for _, rec := range records {
+		if canonical {
+			rec = rec.canonical()
+		}
+		sorted = append(sorted, rec)
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].ID < sorted[j].ID
+	})
+
+	return sorted
+}
+
+// Canonical returns true if HTTP dumps are written split into fields.
+func (c *Cassette) Canonical() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.header.Canonical
+}
+
+// SetCanonical makes the cassette write HTTP dumps split into method, url, headers and body fields
+// with pretty-printed JSON bodies, so cassette changes are readable in code review.
+// The setting is kept in the cassette header, a cassette file that has already been written is rewritten on Finalize.
+func (c *Cassette) SetCanonical(canonical bool) *Cassette {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.header.Canonical = canonical
+	c.dirty = true
+
+	return c
+}
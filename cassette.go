@@ -1,7 +1,6 @@
 package playback
 
 import (
-	"bytes"
 	"context"
 	"database/sql/driver"
 	"errors"
@@ -9,9 +8,12 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/sergi/go-diff/diffmatchpatch"
 	yaml "gopkg.in/yaml.v2"
@@ -30,6 +32,10 @@ type trackMap map[string]*track
 type Cassette struct {
 	ID string
 
+	name       string
+	header     CassetteHeader
+	format     Format
+	dir        *cassetteDir
 	writer     Writer
 	playback   *Playback
 	tracks     map[RecordKind]trackMap
@@ -40,19 +46,47 @@ type Cassette struct {
 	mode       Mode
 	syncMode   SyncMode
 	debug      bool
+	strict     bool
+	latency    Latency
+	maxAge     time.Duration
+	dirty      bool
+	approval   bool
+	faults     faultState
 	logger     Logger
+	added      chan struct{}
 	mu         sync.RWMutex
+
+	missPolicies  map[RecordKind]MissPolicy
+	pending       []*record
+	pendingWriter Writer
+
+	openAPI           *OpenAPI
+	openAPIViolations []OpenAPIViolation
+
+	misses     []*record
+	mismatches []responseMismatch
 }
 
 func newCassette(p *Playback) *Cassette {
 	c := &Cassette{
 		playback: p,
+		header:   newCassetteHeader(),
 		logger:   p.getLogger(),
 		debug:    p.Debug(),
+		strict:   p.StrictOrder(),
+		latency:  p.Latency(),
+		maxAge:   p.MaxAge(),
+		approval: p.Approval(),
+		format:   p.Format(),
+		openAPI:  p.OpenAPI(),
+
+		missPolicies: p.MissPolicies(),
 	}
 	c.ID = p.generateID()
 	c.reset()
 	c.mode = p.Mode()
+	c.header.Canonical = p.Canonical()
+	c.setFaults(p.Faults())
 
 	p.Add(c)
 
@@ -60,7 +94,27 @@ func newCassette(p *Playback) *Cassette {
 }
 
 func newCassetteFromFile(p *Playback, filename string) (*Cassette, error) {
-	dump, err := ioutil.ReadFile(filename)
+	filename, err := p.cassettePath(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var dir *cassetteDir
+	index := filename
+	if info.IsDir() {
+		dir, err = openCassetteDir(filename)
+		if err != nil {
+			return nil, err
+		}
+		index = dir.index
+	}
+
+	dump, err := ioutil.ReadFile(index)
 	if err != nil {
 		return nil, err
 	}
@@ -70,8 +124,14 @@ func newCassetteFromFile(p *Playback, filename string) (*Cassette, error) {
 		return c, err
 	}
 
+	c.dir = dir
 	c.writer = newNilNamed(PathTypeFile, filename)
 
+	err = c.loadPending(index)
+	if err != nil {
+		return c, err
+	}
+
 	return c, nil
 }
 
@@ -80,13 +140,14 @@ func newCassetteFromYAML(p *Playback, dump []byte) (*Cassette, error) {
 		return nil, ErrPlaybackFailed
 	}
 
-	var records []*record
-	err := yaml.Unmarshal(dump, &records)
+	format, header, records, err := unmarshalCassetteFile(dump)
 	if err != nil {
 		return nil, err
 	}
 
 	c := newCassette(p)
+	c.header = header
+	c.format = format
 
 	for _, rec := range records {
 		c.add(rec)
@@ -97,6 +158,30 @@ func newCassetteFromYAML(p *Playback, dump []byte) (*Cassette, error) {
 	return c, nil
 }
 
+// AddFromYAML appends the records of a YAML dump to the cassette after the existing ones.
+// The format of the dump is detected and it is migrated to the current format version first.
+func (c *Cassette) AddFromYAML(dump []byte) error {
+	_, _, records, err := unmarshalCassetteFile(dump)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.locked {
+		c.err = errCassetteLocked
+		return errCassetteLocked
+	}
+
+	for _, rec := range records {
+		rec.ID = 0
+		c.add(rec)
+	}
+
+	return c.writeRecords(records)
+}
+
 func (c *Cassette) Result(key string, value interface{}) interface{} {
 	recorder := newResultRecorder(c, key, value, nil)
 
@@ -124,6 +209,9 @@ func (c *Cassette) SetMode(mode Mode) *Cassette {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if c.mode != mode {
+		c.emit(Event{Type: EventModeChanged, Mode: mode})
+	}
 	c.mode = mode
 
 	return c
@@ -145,6 +233,22 @@ func (c *Cassette) SetDebug(debug bool) *Cassette {
 	return c
 }
 
+func (c *Cassette) StrictOrder() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.strict
+}
+
+func (c *Cassette) SetStrictOrder(strict bool) *Cassette {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.strict = strict
+
+	return c
+}
+
 func (c *Cassette) SetLogger(logger Logger) *Cassette {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -160,12 +264,83 @@ func (c *Cassette) WithFile() (*Cassette, error) {
 
 	var err error
 	c.writer, err = c.newFileForCassette()
+	if err != nil {
+		return c, err
+	}
+
+	err = c.write(string(marshalFileStart(c.format, c.header)))
 	return c, err
 }
 
-func (c *Cassette) newFileForCassette() (*file, error) {
-	f, err := ioutil.TempFile("", c.playback.fileMask)
-	return &file{f}, err
+// Header returns the header written to the cassette file.
+func (c *Cassette) Header() CassetteHeader {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	header := c.header
+	header.Tags = append([]string(nil), c.header.Tags...)
+
+	return header
+}
+
+// CreatedAt returns the creation time of the cassette.
+func (c *Cassette) CreatedAt() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.header.CreatedAt
+}
+
+// Tags returns the tags of the cassette.
+func (c *Cassette) Tags() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return append([]string(nil), c.header.Tags...)
+}
+
+// SetTags sets the tags of the cassette.
+// In ModeRecord a cassette file that has already been written is rewritten on Finalize,
+// in other modes the tags are kept in memory only.
+func (c *Cassette) SetTags(tags ...string) *Cassette {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.header.Tags = append([]string(nil), tags...)
+	if c.mode == ModeRecord {
+		c.dirty = true
+	}
+
+	return c
+}
+
+func (c *Cassette) newFileForCassette() (Writer, error) {
+	if c.playback.Layout() == LayoutDir {
+		return c.newDirForCassette()
+	}
+
+	f, err := ioutil.TempFile(c.playback.CassetteRoot(), fileMaskForFormat(c.playback.fileMask, c.format, c.playback.Compressed()))
+	if err != nil {
+		return nil, err
+	}
+
+	return newFileWriter(f), nil
+}
+
+func (c *Cassette) newDirForCassette() (Writer, error) {
+	mask := strings.TrimSuffix(c.playback.fileMask, compressedSuffix)
+	dir, err := ioutil.TempDir(c.playback.CassetteRoot(), strings.TrimSuffix(mask, filepath.Ext(mask)))
+	if err != nil {
+		return nil, err
+	}
+
+	c.dir = newCassetteDir(dir, c.format, c.playback.Compressed())
+	f, err := os.Create(c.dir.index)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dirWriter{Writer: newFileWriter(f), dir: dir}, nil
 }
 
 func (c *Cassette) SyncMode() SyncMode {
@@ -187,12 +362,14 @@ func (c *Cassette) Rewind() {
 	defer c.mu.Unlock()
 
 	c.err = nil
+	c.misses, c.mismatches = nil, nil
+	c.setFaults(c.faults.faults)
 
 	c.recordByID = make(map[uint64]*record, 10)
 
 	for _, kindTracks := range c.tracks {
 		for _, keyTrack := range kindTracks {
-			keyTrack.cursor = 0
+			keyTrack.rewind()
 		}
 	}
 }
@@ -202,8 +379,10 @@ func (c *Cassette) reset() {
 
 	c.recID = 0
 	c.err = nil
+	c.misses, c.mismatches = nil, nil
 	c.recordByID = make(map[uint64]*record, 10)
 	c.tracks = make(map[RecordKind]trackMap, 5)
+	c.added = make(chan struct{})
 
 	for _, kindTracks := range c.tracks {
 		for _, keyTrack := range kindTracks {
@@ -250,12 +429,12 @@ func (c *Cassette) IsPlaybackSucceeded() bool {
 	}
 
 	for kind, kindTracks := range c.tracks {
-		if kind == KindHTTPRequest || kind == KindGRPCRequest {
+		if isIncomingKind(kind) {
 			continue
 		}
 
 		for _, keyTrack := range kindTracks {
-			if keyTrack.cursor != len(keyTrack.records) {
+			if !keyTrack.succeeded() {
 				return false
 			}
 		}
@@ -265,6 +444,8 @@ func (c *Cassette) IsPlaybackSucceeded() bool {
 }
 
 func (c *Cassette) IsGRPCResponseCorrect(res interface{}) bool {
+	actual := yamlMarshalString(res)
+
 	resExpected := reflect.New(reflect.TypeOf(res).Elem()).Interface()
 	err := c.GRPCResponse(resExpected)
 	if err != nil {
@@ -276,7 +457,12 @@ func (c *Cassette) IsGRPCResponseCorrect(res interface{}) bool {
 		return false
 	}
 
-	return reflect.DeepEqual(resExpected, res)
+	if !reflect.DeepEqual(resExpected, res) {
+		c.addMismatch(KindGRPCRequest, yamlMarshalString(resExpected), actual)
+		return false
+	}
+
+	return true
 }
 
 func (c *Cassette) IsHTTPResponseCorrect(res *http.Response) bool {
@@ -290,7 +476,13 @@ func (c *Cassette) IsHTTPResponseCorrect(res *http.Response) bool {
 	resExpected, _ := c.HTTPResponse(req)
 	resExpected = httpDeleteHeaders(httpCopyResponse(resExpected, req))
 
-	return httpDumpResponse(res) == httpDumpResponse(resExpected)
+	actual, expected := httpDumpResponse(res), httpDumpResponse(resExpected)
+	if actual != expected {
+		c.addMismatch(KindHTTPRequest, expected, actual)
+		return false
+	}
+
+	return true
 }
 
 func (c *Cassette) write(content string) error {
@@ -321,6 +513,10 @@ func (c *Cassette) Sync() error {
 }
 
 func (c *Cassette) sync() error {
+	if c.writer == nil {
+		return nil
+	}
+
 	return c.writer.Sync()
 }
 
@@ -330,9 +526,26 @@ func (c *Cassette) Finalize() error {
 
 	c.lock()
 
-	if c.writer != nil {
-		return c.writer.Close()
+	if c.writer == nil {
+		return nil
 	}
+
+	err := c.writer.Close()
+	if err != nil {
+		return err
+	}
+
+	if c.pendingWriter != nil {
+		err = c.pendingWriter.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.dirty {
+		return c.rewrite()
+	}
+
 	return nil
 }
 
@@ -361,6 +574,8 @@ func (c *Cassette) PathName() string {
 func (c *Cassette) setID(rec *record) {
 	if rec.ID == 0 {
 		rec.setID(c.nextRecordID())
+	} else if rec.ID > c.recID {
+		c.recID = rec.ID
 	}
 }
 
@@ -524,14 +739,58 @@ func (c *Cassette) Get(kind RecordKind, key string) (*record, error) {
 	return c.get(kind, key)
 }
 
+func (c *Cassette) match(lookup *record) (*record, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.hasNext(lookup.Kind, lookup.Key) {
+		if rec, track := c.getByRule(lookup); rec != nil {
+			return c.playRecord(track, rec)
+		}
+	}
+
+	return c.getForRequest(lookup.Kind, lookup.Key, lookup.Request)
+}
+
 func (c *Cassette) get(kind RecordKind, key string) (*record, error) {
+	return c.getForRequest(kind, key, "")
+}
+
+func (c *Cassette) getForRequest(kind RecordKind, key string, request string) (*record, error) {
 	track, err := c.getTrack(kind, key)
 	if err != nil {
 		return nil, err
 	}
 
-	rec := track.records[track.cursor]
-	track.cursor++
+	rec := track.next(request)
+	if rec == nil {
+		c.err = errCassetteGetFailed
+		return nil, errCassetteGetFailed
+	}
+
+	return c.playRecord(track, rec)
+}
+
+func (c *Cassette) playRecord(track *track, rec *record) (*record, error) {
+	if rec.Generated && c.skipsGenerated() {
+		c.err = errCassetteGetFailed
+		return nil, errCassetteGetFailed
+	}
+
+	if c.strict {
+		err := c.checkOrder(rec)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := c.loadBodyLocked(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	track.play(rec)
+	c.emitRecord(EventRecordPlayed, rec)
 
 	return rec, nil
 }
@@ -568,7 +827,7 @@ func (c *Cassette) getByPrefix(kind RecordKind, prefix string) (rec *record, err
 			}
 
 			rec := track.records[track.cursor]
-			return rec, nil
+			return rec, c.loadBodyLocked(rec)
 		}
 	}
 
@@ -587,6 +846,10 @@ func (c *Cassette) getByKind(kind RecordKind) ([]*record, error) {
 	records := make([]*record, 0, len(c.tracks[kind])*2)
 	for _, track := range c.tracks[kind] {
 		for _, rec := range track.records {
+			err := c.loadBodyLocked(rec)
+			if err != nil {
+				return nil, err
+			}
 			records = append(records, rec)
 		}
 	}
@@ -614,8 +877,8 @@ func (c *Cassette) Keys() map[RecordKind]map[string]struct{} {
 }
 
 func (c *Cassette) GetLast(kind RecordKind, key string) (rec *record, err error) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
 	if c.tracks[kind] == nil || c.tracks[kind][key] == nil {
 		c.err = errCassetteGetFailed
@@ -630,7 +893,7 @@ func (c *Cassette) GetLast(kind RecordKind, key string) (rec *record, err error)
 
 	rec = track.records[len(track.records)-1]
 
-	return rec, nil
+	return rec, c.loadBodyLocked(rec)
 }
 
 func (c *Cassette) Add(rec *record) error {
@@ -642,19 +905,42 @@ func (c *Cassette) Add(rec *record) error {
 		return errCassetteLocked
 	}
 
+	return c.addLocked(rec)
+}
+
+func (c *Cassette) addLocked(rec *record) error {
+	if rec.CreatedAt.IsZero() {
+		rec.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	}
+
+	if rec.Pending || c.needsApproval() {
+		return c.addPending(rec)
+	}
+
+	if c.replaceGenerated(rec) {
+		c.emitRecord(EventRecordAdded, rec)
+		return nil
+	}
+
 	c.add(rec)
-	marshalled := yamlMarshalString([]*record{rec})
-	return c.write(marshalled)
+	c.emitRecord(EventRecordAdded, rec)
+	return c.writeRecords([]*record{rec})
 }
 
 func (c *Cassette) add(rec *record) {
 	c.setID(rec)
+	if rec.Match != nil {
+		rec.Match.compile()
+	}
 	if c.recordByID[rec.ID] != nil {
 		*(c.recordByID[rec.ID]) = *rec
 		return
 	}
 
 	c.recordByID[rec.ID] = rec
+	if c.mode == ModeRefreshStale {
+		c.dirty = true
+	}
 
 	if c.tracks[rec.Kind] == nil {
 		c.tracks[rec.Kind] = make(trackMap, 5)
@@ -667,6 +953,8 @@ func (c *Cassette) add(rec *record) {
 	}
 	track := c.tracks[rec.Kind][rec.Key]
 	track.records = append(track.records, rec)
+
+	c.notifyRecordAdded()
 }
 
 func (c *Cassette) debugRecordMatch(rec *record, kind RecordKind, prefix string) {
@@ -710,14 +998,28 @@ func (c *Cassette) MarshalToYAML() []byte {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	var buf bytes.Buffer
+	return marshalCassetteFile(FormatYAML, c.header, c.resolved(c.records()))
+}
+
+// marshal encodes the cassette as it is written to its file.
+func (c *Cassette) marshal() ([]byte, error) {
+	records, err := c.stored(c.records())
+	if err != nil {
+		return nil, err
+	}
+
+	return marshalCassetteFile(c.format, c.header, records), nil
+}
+
+func (c *Cassette) records() []*record {
+	records := make([]*record, 0, len(c.recordByID))
 	for _, kindTracks := range c.tracks {
 		for _, keyTrack := range kindTracks {
-			buf.Write(yamlMarshal(keyTrack.records))
+			records = append(records, keyTrack.records...)
 		}
 	}
 
-	return buf.Bytes()
+	return records
 }
 
 func (c *Cassette) Run(recorder Recorder) error {
@@ -729,18 +1031,20 @@ func (c *Cassette) Run(recorder Recorder) error {
 	case ModeOff:
 		return recorder.Call()
 	case ModePlayback:
-		return recorder.Playback()
+		return c.replay(recorder)
 
 	case ModePlaybackOrRecord:
-		err := recorder.Playback()
+		err := c.playbackRecorder(recorder)
 		if err == ErrPlaybackFailed {
+			c.emitMiss(recorder)
 			return recorder.Record()
 		}
 		return err
 
 	case ModePlaybackSuccessOrRecord:
-		err := recorder.Playback()
+		err := c.playbackRecorder(recorder)
 		if err != nil {
+			c.emitMiss(recorder)
 			return recorder.Record()
 		}
 		return err
@@ -748,6 +1052,9 @@ func (c *Cassette) Run(recorder Recorder) error {
 	case ModeRecord:
 		return recorder.Record()
 
+	case ModeRefreshStale:
+		return c.refreshStale(recorder)
+
 	}
 
 	return recorder.Call()
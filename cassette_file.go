@@ -0,0 +1,193 @@
+package playback
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// FormatVersion is the version of the cassette file format written by the library.
+	FormatVersion = 2
+	// LibraryVersion is written to the header of the cassettes created by the library.
+	LibraryVersion = "v0.3.0"
+)
+
+var errCassetteVersionUnsupported = errors.New("Cassette format version is unsupported")
+
+// CassetteHeader describes a cassette file.
+// Files without a header have format version 1.
+type CassetteHeader struct {
+	Version   int       `yaml:"version" json:"version"`
+	Library   string    `yaml:"library,omitempty" json:"library,omitempty"`
+	CreatedAt time.Time `yaml:"created_at,omitempty" json:"created_at,omitzero"`
+	Tags      []string  `yaml:"tags,omitempty" json:"tags,omitempty"`
+	Canonical bool      `yaml:"canonical,omitempty" json:"canonical,omitempty"`
+}
+
+func newCassetteHeader() CassetteHeader {
+	return CassetteHeader{
+		Version:   FormatVersion,
+		Library:   LibraryVersion,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+}
+
+type cassetteFile struct {
+	CassetteHeader `yaml:",inline"`
+	Records        []*record `yaml:"records" json:"records"`
+}
+
+type rawCassetteFile struct {
+	CassetteHeader `yaml:",inline"`
+	Records        []map[string]interface{} `yaml:"records" json:"records"`
+}
+
+// Migration upgrades the records of a cassette file by one format version.
+type Migration func(records []map[string]interface{}) ([]map[string]interface{}, error)
+
+var migrations = struct {
+	byVersion map[int]Migration
+	mu        sync.RWMutex
+}{
+	byVersion: map[int]Migration{
+		1: migrateHeaderless,
+	},
+}
+
+// RegisterMigration registers the migration of cassette files from the version to the next one.
+// Cassette files are migrated to FormatVersion on load.
+func RegisterMigration(from int, migration Migration) {
+	migrations.mu.Lock()
+	defer migrations.mu.Unlock()
+
+	migrations.byVersion[from] = migration
+}
+
+func migration(from int) Migration {
+	migrations.mu.RLock()
+	defer migrations.mu.RUnlock()
+
+	return migrations.byVersion[from]
+}
+
+// migrateHeaderless upgrades version 1 files: a bare list of records without a header.
+// The records themselves are unchanged.
+func migrateHeaderless(records []map[string]interface{}) ([]map[string]interface{}, error) {
+	return records, nil
+}
+
+func marshalCassetteHeader(header CassetteHeader) string {
+	return yamlMarshalString(header) + "records:\n"
+}
+
+func marshalCassetteYAML(header CassetteHeader, records []*record) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(marshalCassetteHeader(header))
+	buf.Write(marshalRecords(records, header.Canonical))
+
+	return buf.Bytes()
+}
+
+// unmarshalCassetteYAML decodes a YAML cassette file of any known version and migrates it to FormatVersion.
+func unmarshalCassetteYAML(dump []byte) (CassetteHeader, []*record, error) {
+	var probe interface{}
+	err := yaml.Unmarshal(dump, &probe)
+	if err != nil {
+		return CassetteHeader{}, nil, err
+	}
+
+	if _, ok := probe.([]interface{}); !ok {
+		var file cassetteFile
+		err = yaml.Unmarshal(dump, &file)
+		if err != nil {
+			return file.CassetteHeader, nil, err
+		}
+
+		if file.Version == FormatVersion {
+			joinHTTPMessages(file.Records)
+			return file.CassetteHeader, file.Records, nil
+		}
+	}
+
+	var raw rawCassetteFile
+	if _, ok := probe.([]interface{}); ok {
+		raw.Version = 1
+		err = yaml.Unmarshal(dump, &raw.Records)
+	} else {
+		err = yaml.Unmarshal(dump, &raw)
+	}
+	if err != nil {
+		return raw.CassetteHeader, nil, err
+	}
+
+	return migrateCassetteFile(raw, unmarshalRawYAML)
+}
+
+func unmarshalRawYAML(raw []map[string]interface{}) ([]*record, error) {
+	var records []*record
+	err := yaml.Unmarshal(yamlMarshal(raw), &records)
+
+	return records, err
+}
+
+func migrateCassetteFile(raw rawCassetteFile, unmarshalRaw func([]map[string]interface{}) ([]*record, error)) (CassetteHeader, []*record, error) {
+	header := raw.CassetteHeader
+	if header.Version > FormatVersion || header.Version < 1 {
+		return header, nil, fmt.Errorf("%s: %d", errCassetteVersionUnsupported, header.Version)
+	}
+
+	var err error
+	for ; header.Version < FormatVersion; header.Version++ {
+		migrate := migration(header.Version)
+		if migrate == nil {
+			return header, nil, fmt.Errorf("%s: no migration from %d", errCassetteVersionUnsupported, header.Version)
+		}
+
+		raw.Records, err = migrate(raw.Records)
+		if err != nil {
+			return header, nil, err
+		}
+	}
+
+	if header.Library == "" {
+		header.Library = LibraryVersion
+	}
+	if header.CreatedAt.IsZero() {
+		header.CreatedAt = newCassetteHeader().CreatedAt
+	}
+
+	records, err := unmarshalRaw(raw.Records)
+	joinHTTPMessages(records)
+
+	return header, records, err
+}
+
+// MigrateFile rewrites a cassette file or the index of a cassette directory in the current format version.
+func MigrateFile(filename string) error {
+	if info, err := os.Stat(filename); err == nil && info.IsDir() {
+		dir, err := openCassetteDir(filename)
+		if err != nil {
+			return err
+		}
+		filename = dir.index
+	}
+
+	dump, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	format, header, records, err := unmarshalCassetteFile(dump)
+	if err != nil {
+		return err
+	}
+
+	return writeCassetteFile(filename, marshalCassetteFile(format, header, records))
+}
@@ -0,0 +1,214 @@
+// Command playback maintains cassette files.
+//
+//	playback pending <cassette>          prints records awaiting approval
+//	playback approve <cassette> [ids]    moves pending records into the cassette
+//	playback reject <cassette> [ids]     drops pending records
+//	playback migrate <cassette>...       rewrites cassettes in the current format version
+//	playback export-har <cassette>       prints http records in HAR format
+//	playback import-har <cassette> <har> [http|http_request]
+//	                                     writes a new cassette made of HAR entries
+//	playback import-vcr <cassette> <go-vcr cassette>
+//	                                     writes a new cassette made of go-vcr interactions
+//	playback import-wiremock <cassette> <mappings>
+//	                                     writes a new cassette made of WireMock stub mappings
+//	playback export-wiremock <cassette>  prints http records as WireMock stub mappings
+//	playback export-pact <cassette> <dir> <consumer> [volatile json paths]
+//	                                     writes a Pact contract per provider host
+//	playback validate-openapi <cassette> <openapi>
+//	                                     prints http records violating an OpenAPI document
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/wtertius/playback"
+)
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	err := run(flag.Arg(0), flag.Arg(1), flag.Args()[2:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s pending|approve|reject <cassette> [record ids]\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "       %s migrate <cassette>...\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "       %s export-har <cassette>\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "       %s import-har <cassette> <har> [http|http_request]\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "       %s import-vcr|import-wiremock <cassette> <source>\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "       %s export-wiremock <cassette>\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "       %s export-pact <cassette> <dir> <consumer> [volatile json paths]\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "       %s validate-openapi <cassette> <openapi>\n", os.Args[0])
+}
+
+func run(command, filename string, args []string) error {
+	switch command {
+	case "migrate":
+		return migrate(append([]string{filename}, args...))
+	case "import-har":
+		return importHAR(filename, args)
+	case "import-vcr":
+		return importFile(filename, args, playback.New().CassetteFromGoVCR)
+	case "import-wiremock":
+		return importFile(filename, args, playback.New().CassetteFromWireMock)
+	}
+
+	cassette, err := playback.New().CassetteFromFile(filename)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "export-pact":
+		return exportPact(cassette, args)
+	case "validate-openapi":
+		return validateOpenAPI(cassette, args)
+	}
+
+	ids, err := parseIDs(args)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "pending":
+		_, err = os.Stdout.Write(cassette.MarshalPendingToYAML())
+		return err
+	case "approve":
+		return cassette.Approve(ids...)
+	case "reject":
+		return cassette.Reject(ids...)
+	case "export-har":
+		return export(cassette.MarshalToHAR)
+	case "export-wiremock":
+		return export(cassette.MarshalToWireMock)
+	}
+
+	return fmt.Errorf("Unknown command %q", command)
+}
+
+func migrate(filenames []string) error {
+	for _, filename := range filenames {
+		err := playback.MigrateFile(filename)
+		if err != nil {
+			return fmt.Errorf("%s: %s", filename, err)
+		}
+	}
+
+	return nil
+}
+
+func importHAR(filename string, args []string) error {
+	if len(args) != 1 && len(args) != 2 {
+		return fmt.Errorf("Usage: import-har <cassette> <har> [http|http_request]")
+	}
+
+	var kind playback.RecordKind
+	if len(args) == 2 {
+		kind = playback.RecordKind(args[1])
+	}
+
+	return importFile(filename, args[:1], func(har []byte) (*playback.Cassette, error) {
+		return playback.New().CassetteFromHAR(har, kind)
+	})
+}
+
+func importFile(filename string, args []string, convert func([]byte) (*playback.Cassette, error)) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Usage: import-* <cassette> <source>")
+	}
+
+	dump, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	cassette, err := convert(dump)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filename, cassette.MarshalToYAML(), 0644)
+}
+
+func export(marshal func() ([]byte, error)) error {
+	dump, err := marshal()
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stdout.Write(dump)
+	return err
+}
+
+func exportPact(cassette *playback.Cassette, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("Usage: export-pact <cassette> <dir> <consumer> [volatile json paths]")
+	}
+
+	pacts, err := cassette.MarshalToPact(playback.PactOptions{
+		Consumer: args[1],
+		Volatile: args[2:],
+	})
+	if err != nil {
+		return err
+	}
+
+	for provider, dump := range pacts {
+		err = ioutil.WriteFile(filepath.Join(args[0], playback.PactFilename(args[1], provider)), dump, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateOpenAPI(cassette *playback.Cassette, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Usage: validate-openapi <cassette> <openapi>")
+	}
+
+	spec, err := playback.LoadOpenAPI(args[0])
+	if err != nil {
+		return err
+	}
+
+	violations := cassette.ValidateOpenAPI(spec)
+	for _, violation := range violations {
+		fmt.Println(violation)
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("%d OpenAPI violations", len(violations))
+	}
+
+	return nil
+}
+
+func parseIDs(args []string) ([]uint64, error) {
+	ids := make([]uint64, 0, len(args))
+	for _, arg := range args {
+		id, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid record id %q", arg)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,123 @@
+package playback
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const compressedSuffix = ".gz"
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+func isCompressedName(name string) bool {
+	return strings.HasSuffix(name, compressedSuffix)
+}
+
+// gzipFile writes every chunk as a separate gzip member,
+// so a file cut short by a crash still has all the previous records readable.
+type gzipFile struct {
+	*file
+}
+
+func newFileWriter(f *os.File) Writer {
+	if isCompressedName(f.Name()) {
+		return &gzipFile{&file{f}}
+	}
+
+	return &file{f}
+}
+
+func (f *gzipFile) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	w := gzip.NewWriter(f.File)
+	_, err := w.Write(p)
+	if err != nil {
+		return 0, err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (f *gzipFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+// decompress unpacks gzip dumps member by member.
+// A broken last member, left by a crash in the middle of a write, is dropped.
+func decompress(dump []byte) ([]byte, error) {
+	if !bytes.HasPrefix(dump, gzipMagic) {
+		return dump, nil
+	}
+
+	src := bytes.NewReader(dump)
+	r, err := gzip.NewReader(src)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var buf bytes.Buffer
+	for {
+		r.Multistream(false)
+
+		member, err := ioutil.ReadAll(r)
+		if err != nil {
+			if buf.Len() == 0 {
+				return nil, err
+			}
+			break
+		}
+		buf.Write(member)
+
+		// Reset fails with io.EOF after the last member
+		if r.Reset(src) != nil {
+			break
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+func compressFor(filename string, content []byte) []byte {
+	if !isCompressedName(filename) || len(content) == 0 {
+		return content
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(content)
+	w.Close()
+
+	return buf.Bytes()
+}
+
+func writeCassetteFile(filename string, content []byte) error {
+	return ioutil.WriteFile(filename, compressFor(filename, content), 0644)
+}
+
+func (p *Playback) SetCompressed(compressed bool) *Playback {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.compressed = compressed
+
+	return p
+}
+
+func (p *Playback) Compressed() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.compressed
+}
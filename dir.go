@@ -0,0 +1,281 @@
+package playback
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// Layout is the way a cassette is kept on disk.
+type Layout string
+
+const (
+	// LayoutFile keeps the whole cassette in a single file.
+	LayoutFile Layout = ""
+	// LayoutDir keeps the records in an index file of a directory
+	// and HTTP response bodies in content-addressed files in its bodies subdirectory.
+	LayoutDir Layout = "dir"
+
+	indexBasename = "index"
+	bodiesDir     = "bodies"
+)
+
+var errBodyRefInvalid = errors.New("Invalid body reference")
+
+var bodyExtensions = map[string]string{
+	"application/json":         ".json",
+	"application/xml":          ".xml",
+	"application/x-protobuf":   ".bin",
+	"application/octet-stream": ".bin",
+	"text/html":                ".html",
+	"text/xml":                 ".xml",
+	"text/plain":               ".txt",
+	"text/css":                 ".css",
+	"image/png":                ".png",
+	"image/jpeg":               ".jpg",
+	"image/gif":                ".gif",
+}
+
+// cassetteDir is a cassette directory: the index file and the bodies.
+// Directories of a file system other than the OS one are read-only.
+type cassetteDir struct {
+	fsys  fs.FS
+	dir   string
+	index string
+}
+
+func newCassetteDir(dir string, format Format, compressed bool) *cassetteDir {
+	index := indexBasename + formatExtensions[format]
+	if compressed {
+		index += compressedSuffix
+	}
+
+	return &cassetteDir{
+		dir:   dir,
+		index: filepath.Join(dir, index),
+	}
+}
+
+// openCassetteDir finds the index file of a cassette directory.
+func openCassetteDir(dir string) (*cassetteDir, error) {
+	return openCassetteDirFS(nil, dir)
+}
+
+// openCassetteDirFS finds the index file of a cassette directory of the file system, the OS one if nil.
+func openCassetteDirFS(fsys fs.FS, dir string) (*cassetteDir, error) {
+	join, stat := filepath.Join, os.Stat
+	if fsys != nil {
+		join = path.Join
+		stat = func(name string) (os.FileInfo, error) {
+			return fs.Stat(fsys, name)
+		}
+	}
+
+	for _, ext := range []string{".yml", ".yaml", ".json", ".jsonl"} {
+		for _, suffix := range []string{"", compressedSuffix} {
+			index := join(dir, indexBasename+ext+suffix)
+			if _, err := stat(index); err == nil {
+				return &cassetteDir{fsys: fsys, dir: dir, index: index}, nil
+			}
+		}
+	}
+
+	return nil, &os.PathError{Op: "open", Path: join(dir, indexBasename+".yml"), Err: os.ErrNotExist}
+}
+
+// save stores the body under the name made of its hash, equal bodies are stored once.
+func (d *cassetteDir) save(body []byte, contentType string) (string, error) {
+	if d.fsys != nil {
+		return "", errStoreReadOnly
+	}
+
+	sum := sha256.Sum256(body)
+	ref := path.Join(bodiesDir, hex.EncodeToString(sum[:])+bodyExtension(body, contentType))
+
+	filename := filepath.Join(d.dir, filepath.FromSlash(ref))
+	if _, err := os.Stat(filename); err == nil {
+		return ref, nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return "", err
+	}
+
+	return ref, ioutil.WriteFile(filename, body, 0644)
+}
+
+func (d *cassetteDir) load(ref string) ([]byte, error) {
+	if path.Dir(path.Clean(ref)) != bodiesDir {
+		return nil, errBodyRefInvalid
+	}
+
+	if d.fsys != nil {
+		return fs.ReadFile(d.fsys, path.Join(d.dir, path.Clean(ref)))
+	}
+
+	return ioutil.ReadFile(filepath.Join(d.dir, filepath.FromSlash(path.Clean(ref))))
+}
+
+func bodyExtension(body []byte, contentType string) string {
+	mediaType := strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	if ext, ok := bodyExtensions[strings.ToLower(mediaType)]; ok {
+		return ext
+	}
+	if strings.HasSuffix(mediaType, "+json") || json.Valid(body) {
+		return ".json"
+	}
+	if utf8.Valid(body) {
+		return ".txt"
+	}
+
+	return ".bin"
+}
+
+// splitHTTPDump splits an HTTP dump into the head ending with an empty line and the body.
+func splitHTTPDump(dump string) (string, string) {
+	end := strings.Index(dump, httpHeaderEnd)
+	if end < 0 {
+		return dump, ""
+	}
+	end += len(httpHeaderEnd)
+
+	return dump[:end], dump[end:]
+}
+
+func httpDumpHeader(head string, name string) string {
+	for _, line := range strings.Split(head, "\r\n")[1:] {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) == 2 && strings.EqualFold(strings.TrimSpace(parts[0]), name) {
+			return strings.TrimSpace(parts[1])
+		}
+	}
+
+	return ""
+}
+
+// stored returns the records as they are written to the cassette file.
+// In a cassette directory HTTP response bodies are moved to separate files.
+func (c *Cassette) stored(records []*record) ([]*record, error) {
+	if c.dir == nil {
+		return records, nil
+	}
+
+	stored := make([]*record, 0, len(records))
+	for _, rec := range records {
+		if rec.Kind != KindHTTP || rec.ResponseBody != "" {
+			stored = append(stored, rec)
+			continue
+		}
+
+		head, body := splitHTTPDump(rec.Response)
+		if body == "" {
+			stored = append(stored, rec)
+			continue
+		}
+
+		ref, err := c.dir.save([]byte(body), httpDumpHeader(head, "Content-Type"))
+		if err != nil {
+			return nil, err
+		}
+
+		copied := *rec
+		copied.Response, copied.ResponseBody = head, ref
+		stored = append(stored, &copied)
+	}
+
+	return stored, nil
+}
+
+// resolved returns copies of the records with the bodies kept in separate files loaded.
+func (c *Cassette) resolved(records []*record) []*record {
+	if c.dir == nil {
+		return records
+	}
+
+	resolved := make([]*record, 0, len(records))
+	for _, rec := range records {
+		if rec.ResponseBody != "" {
+			copied := *rec
+			c.loadBody(&copied)
+			rec = &copied
+		}
+		resolved = append(resolved, rec)
+	}
+
+	return resolved
+}
+
+// loadBody loads the response body of a record read from a cassette directory on first use.
+func (c *Cassette) loadBody(rec *record) error {
+	if rec == nil || rec.ResponseBody == "" || c.dir == nil {
+		return nil
+	}
+
+	body, err := c.dir.load(rec.ResponseBody)
+	if err != nil {
+		return err
+	}
+
+	rec.Response, rec.ResponseBody = rec.Response+string(body), ""
+
+	return nil
+}
+
+// loadBodyLocked loads the body of a record of the cassette and keeps a failure as the cassette error.
+// The caller must hold the write lock.
+func (c *Cassette) loadBodyLocked(rec *record) error {
+	err := c.loadBody(rec)
+	if err != nil {
+		c.err = err
+	}
+
+	return err
+}
+
+// filename returns the file the records are written to:
+// the cassette file or the index file of the cassette directory.
+func (c *Cassette) filename() string {
+	if c.writer == nil || c.writer.Type() != PathTypeFile || c.writer.Name() == "" {
+		return ""
+	}
+	if c.dir != nil {
+		return c.dir.index
+	}
+
+	return c.writer.Name()
+}
+
+// dirWriter writes the index file of a cassette directory and is named after the directory.
+type dirWriter struct {
+	Writer
+	dir string
+}
+
+func (w *dirWriter) Name() string {
+	return w.dir
+}
+
+func (p *Playback) SetLayout(layout Layout) *Playback {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.layout = layout
+
+	return p
+}
+
+func (p *Playback) Layout() Layout {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.layout
+}
@@ -0,0 +1,161 @@
+package playback
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType is the kind of cassette activity an event reports.
+type EventType string
+
+const (
+	EventRecordAdded   EventType = "record_added"
+	EventRecordPlayed  EventType = "record_played"
+	EventRecordUpdated EventType = "record_updated"
+	EventRecordDeleted EventType = "record_deleted"
+	EventMiss          EventType = "miss"
+	EventModeChanged   EventType = "mode_changed"
+)
+
+// EventsPath is the path of the Server-Sent Events endpoint of the service.
+const EventsPath = "/playback/events/"
+
+// eventBuffer is the number of events kept for a slow subscriber before new ones are dropped.
+const eventBuffer = 256
+
+// Event is an activity of a cassette of the Playback.
+type Event struct {
+	Type       EventType  `json:"type"`
+	CassetteID string     `json:"cassette_id"`
+	Kind       RecordKind `json:"kind,omitempty"`
+	Key        string     `json:"key,omitempty"`
+	RecordID   uint64     `json:"record_id,omitempty"`
+	Mode       Mode       `json:"mode,omitempty"`
+	Time       time.Time  `json:"time"`
+}
+
+// EventFilter selects the events of a subscription. Empty fields match every event.
+// Mode changes have no kind and pass the kind filter.
+type EventFilter struct {
+	CassetteID string
+	Kinds      []RecordKind
+}
+
+func (f EventFilter) match(event Event) bool {
+	if f.CassetteID != "" && f.CassetteID != event.CassetteID {
+		return false
+	}
+	if len(f.Kinds) == 0 || event.Kind == "" {
+		return true
+	}
+
+	for _, kind := range f.Kinds {
+		if kind == event.Kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Subscription receives the events matching its filter until it is closed.
+// Events are dropped while the subscriber lags behind.
+type Subscription struct {
+	filter EventFilter
+	events chan Event
+	bus    *eventBus
+	once   sync.Once
+}
+
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Close stops the subscription and closes its channel.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.bus.unsubscribe(s)
+	})
+}
+
+type eventBus struct {
+	subscriptions map[*Subscription]struct{}
+	mu            sync.RWMutex
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subscriptions: make(map[*Subscription]struct{})}
+}
+
+func (b *eventBus) subscribe(filter EventFilter) *Subscription {
+	s := &Subscription{
+		filter: filter,
+		events: make(chan Event, eventBuffer),
+		bus:    b,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.subscriptions[s] = struct{}{}
+
+	return s
+}
+
+func (b *eventBus) unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.subscriptions, s)
+	close(s.events)
+}
+
+// publish never blocks, so it may be called with the cassette locked.
+func (b *eventBus) publish(event Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for s := range b.subscriptions {
+		if !s.filter.match(event) {
+			continue
+		}
+
+		select {
+		case s.events <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a subscription to the record-added, record-played, record-updated, record-deleted,
+// miss and mode-change events of the cassettes matching the filter.
+func (p *Playback) Subscribe(filter EventFilter) *Subscription {
+	return p.events.subscribe(filter)
+}
+
+func (c *Cassette) emit(event Event) {
+	if c.playback == nil || c.playback.events == nil {
+		return
+	}
+
+	event.CassetteID = c.ID
+	event.Time = time.Now().UTC()
+
+	c.playback.events.publish(event)
+}
+
+func (c *Cassette) emitRecord(eventType EventType, rec *record) {
+	c.emit(Event{Type: eventType, Kind: rec.Kind, Key: rec.Key, RecordID: rec.ID})
+}
+
+// emitMiss reports the call of the recorder no record matched.
+func (c *Cassette) emitMiss(recorder Recorder) {
+	outcome, ok := recorder.(outcomeRecorder)
+	if !ok || outcome.lookup() == nil {
+		c.emit(Event{Type: EventMiss})
+		return
+	}
+
+	rec := outcome.lookup()
+	c.emit(Event{Type: EventMiss, Kind: rec.Kind, Key: rec.Key})
+}
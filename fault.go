@@ -0,0 +1,220 @@
+package playback
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+var ErrFaultInjected = errors.New("Fault injected")
+
+// FaultKind defines the fault that replaces a replayed record.
+type FaultKind string
+
+const (
+	// FaultError fails the call with FaultRule.Err or ErrFaultInjected.
+	FaultError FaultKind = "error"
+	// FaultStatus answers an HTTP call with FaultRule.StatusCode, 500 by default.
+	FaultStatus FaultKind = "status"
+	// FaultTruncate cuts an HTTP response body in half and breaks it with io.ErrUnexpectedEOF.
+	FaultTruncate FaultKind = "truncate"
+	// FaultSlow delays the call by FaultRule.Delay respecting the call context.
+	FaultSlow FaultKind = "slow"
+	// FaultPanic panics with a diagnostic message.
+	FaultPanic FaultKind = "panic"
+)
+
+// FaultRule matches replayed records by kind and key.
+// An empty Kind and a nil Key match any record.
+// A zero Probability injects the fault on every match.
+type FaultRule struct {
+	Kind        RecordKind
+	Key         *regexp.Regexp
+	Probability float64
+	Fault       FaultKind
+	StatusCode  int
+	Err         error
+	Delay       time.Duration
+}
+
+// Faults configures fault injection in playback mode.
+// Rules are checked in order and the first one that fires wins.
+// The same Seed and the same sequence of calls inject the same faults.
+type Faults struct {
+	Seed  int64
+	Rules []FaultRule
+}
+
+// InjectedFault describes a fault injected into a replayed call.
+type InjectedFault struct {
+	Call   int
+	Rule   int
+	Fault  FaultKind
+	Record RecordRef
+}
+
+func (f InjectedFault) String() string {
+	return fmt.Sprintf("call %d: rule %d injected %s into record %s", f.Call, f.Rule, f.Fault, f.Record)
+}
+
+type faultState struct {
+	faults   Faults
+	rand     *rand.Rand
+	calls    int
+	injected []InjectedFault
+}
+
+func (c *Cassette) SetFaults(faults Faults) *Cassette {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.setFaults(faults)
+
+	return c
+}
+
+func (c *Cassette) setFaults(faults Faults) {
+	c.faults = faultState{
+		faults: faults,
+		rand:   rand.New(rand.NewSource(faults.Seed)),
+	}
+}
+
+func (c *Cassette) InjectedFaults() []InjectedFault {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	injected := make([]InjectedFault, len(c.faults.injected))
+	copy(injected, c.faults.injected)
+
+	return injected
+}
+
+func (rule FaultRule) matches(rec *record, isHTTP bool) bool {
+	if rule.Kind != "" && rule.Kind != rec.Kind {
+		return false
+	}
+	if rule.Key != nil && !rule.Key.MatchString(rec.Key) {
+		return false
+	}
+	if (rule.Fault == FaultStatus || rule.Fault == FaultTruncate) && !isHTTP {
+		return false
+	}
+
+	return true
+}
+
+// drawFault picks the rule to fire for the call and describes the fault it injects.
+func (c *Cassette) drawFault(rec *record, isHTTP bool) (FaultRule, InjectedFault, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.faults.faults.Rules) == 0 {
+		return FaultRule{}, InjectedFault{}, false
+	}
+
+	c.faults.calls++
+	for i, rule := range c.faults.faults.Rules {
+		if !rule.matches(rec, isHTTP) {
+			continue
+		}
+
+		if rule.Probability > 0 && c.faults.rand.Float64() >= rule.Probability {
+			continue
+		}
+
+		return rule, InjectedFault{
+			Call:   c.faults.calls,
+			Rule:   i,
+			Fault:  rule.Fault,
+			Record: newRecordRef(rec),
+		}, true
+	}
+
+	return FaultRule{}, InjectedFault{}, false
+}
+
+func (c *Cassette) logFault(injected InjectedFault) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.faults.injected = append(c.faults.injected, injected)
+}
+
+// injectFault replaces the outcome of a replayed call according to the fault rules.
+func (c *Cassette) injectFault(recorder Recorder, err error) error {
+	outcome, ok := recorder.(outcomeRecorder)
+	if !ok || outcome.lookup() == nil {
+		return err
+	}
+
+	httpOutcome, isHTTP := outcome.(httpOutcomeRecorder)
+
+	rec := outcome.lookup()
+	rule, injected, ok := c.drawFault(rec, isHTTP)
+	if !ok {
+		return err
+	}
+
+	if rule.Fault != FaultTruncate {
+		c.logFault(injected)
+	}
+
+	switch rule.Fault {
+	case FaultError:
+		errFault := rule.Err
+		if errFault == nil {
+			errFault = ErrFaultInjected
+		}
+
+		outcome.fail(errFault)
+		return errFault
+
+	case FaultStatus:
+		statusCode := rule.StatusCode
+		if statusCode == 0 {
+			statusCode = http.StatusInternalServerError
+		}
+
+		httpOutcome.respond(newMissResponse(statusCode, fmt.Sprintf("Fault injected into record %s", newRecordRef(rec))))
+		return nil
+
+	case FaultTruncate:
+		if httpOutcome.truncate() {
+			c.logFault(injected)
+		}
+		return err
+
+	case FaultSlow:
+		if errSleep := c.sleep(outcome, rule.Delay); errSleep != nil {
+			return errSleep
+		}
+		return err
+
+	case FaultPanic:
+		panic(fmt.Sprintf("Fault injected into record %s of cassette %s", newRecordRef(rec), c.ID))
+	}
+
+	return err
+}
+
+func newTruncatedBody(body io.ReadCloser) io.ReadCloser {
+	data, _ := ioutil.ReadAll(body)
+	body.Close()
+
+	return ioutil.NopCloser(io.MultiReader(bytes.NewReader(data[:len(data)/2]), errReader{io.ErrUnexpectedEOF}))
+}
+
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
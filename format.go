@@ -0,0 +1,388 @@
+package playback
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Format is the encoding of a cassette file.
+type Format string
+
+const (
+	// FormatYAML is a YAML document with the header and the list of records.
+	FormatYAML Format = "yaml"
+	// FormatJSON is a JSON document with the header and the list of records.
+	// JSON files can't be appended to, so they are rewritten on every change.
+	FormatJSON Format = "json"
+	// FormatJSONL is a JSON Lines stream: the header on the first line and a record on each next one.
+	FormatJSONL Format = "jsonl"
+)
+
+var formatExtensions = map[Format]string{
+	FormatYAML:  ".yml",
+	FormatJSON:  ".json",
+	FormatJSONL: ".jsonl",
+}
+
+var formatContentTypes = map[Format]string{
+	FormatYAML:  "application/x-yaml",
+	FormatJSON:  "application/json",
+	FormatJSONL: "application/x-ndjson",
+}
+
+// ParseFormat returns the format with the name, an empty name is FormatYAML.
+func ParseFormat(name string) (Format, error) {
+	format := Format(strings.ToLower(name))
+	if format == "" || format == "yml" {
+		return FormatYAML, nil
+	}
+	if _, ok := formatExtensions[format]; !ok {
+		return FormatYAML, fmt.Errorf("Unknown cassette format %q", name)
+	}
+
+	return format, nil
+}
+
+// detectFormat tells the format of a cassette dump by its first character.
+// A JSON object is a FormatJSON document only if it has the records,
+// otherwise it is the first line of a FormatJSONL stream.
+func detectFormat(dump []byte) Format {
+	trimmed := bytes.TrimLeft(dump, " \t\r\n")
+	if len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return FormatYAML
+	}
+
+	if trimmed[0] == '{' {
+		var probe map[string]json.RawMessage
+		if json.Unmarshal(trimmed, &probe) == nil {
+			if _, ok := probe["records"]; ok {
+				return FormatJSON
+			}
+		}
+		return FormatJSONL
+	}
+	if json.Valid(trimmed) {
+		return FormatJSON
+	}
+
+	return FormatYAML
+}
+
+func fileMaskForFormat(mask string, format Format, compressed bool) string {
+	mask = strings.TrimSuffix(mask, compressedSuffix)
+
+	ext := filepath.Ext(mask)
+	if ext == ".yml" || ext == ".yaml" || ext == ".json" || ext == ".jsonl" {
+		mask = strings.TrimSuffix(mask, ext) + formatExtensions[format]
+	}
+	if compressed {
+		mask += compressedSuffix
+	}
+
+	return mask
+}
+
+// unmarshalCassetteFile decodes a cassette file in any format and of any known version
+// and migrates it to FormatVersion.
+// Compressed dumps are unpacked first.
+func unmarshalCassetteFile(dump []byte) (Format, CassetteHeader, []*record, error) {
+	dump, err := decompress(dump)
+	if err != nil {
+		return FormatYAML, CassetteHeader{}, nil, err
+	}
+
+	format := detectFormat(dump)
+
+	var (
+		header  CassetteHeader
+		records []*record
+	)
+	switch format {
+	case FormatJSON:
+		header, records, err = unmarshalCassetteJSON(dump)
+	case FormatJSONL:
+		header, records, err = unmarshalCassetteJSONL(dump)
+	default:
+		header, records, err = unmarshalCassetteYAML(dump)
+	}
+
+	return format, header, records, err
+}
+
+func unmarshalCassetteJSON(dump []byte) (CassetteHeader, []*record, error) {
+	var raw rawCassetteFile
+	if bytes.TrimLeft(dump, " \t\r\n")[0] == '[' {
+		raw.Version = 1
+		err := json.Unmarshal(dump, &raw.Records)
+		if err != nil {
+			return raw.CassetteHeader, nil, err
+		}
+
+		return migrateCassetteFile(raw, unmarshalRawJSON)
+	}
+
+	var file cassetteFile
+	err := json.Unmarshal(dump, &file)
+	if err != nil {
+		return file.CassetteHeader, nil, err
+	}
+
+	if file.Version == FormatVersion {
+		joinHTTPMessages(file.Records)
+		return file.CassetteHeader, file.Records, nil
+	}
+
+	err = json.Unmarshal(dump, &raw)
+	if err != nil {
+		return raw.CassetteHeader, nil, err
+	}
+
+	return migrateCassetteFile(raw, unmarshalRawJSON)
+}
+
+func unmarshalCassetteJSONL(dump []byte) (CassetteHeader, []*record, error) {
+	var lines []json.RawMessage
+	decoder := json.NewDecoder(bytes.NewReader(dump))
+	for {
+		var line json.RawMessage
+		err := decoder.Decode(&line)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return CassetteHeader{}, nil, err
+		}
+
+		lines = append(lines, line)
+	}
+
+	header := CassetteHeader{Version: 1}
+	if len(lines) > 0 {
+		var probe struct {
+			Version *int `json:"version"`
+		}
+		json.Unmarshal(lines[0], &probe)
+		if probe.Version != nil {
+			err := json.Unmarshal(lines[0], &header)
+			if err != nil {
+				return header, nil, err
+			}
+			lines = lines[1:]
+		}
+	}
+
+	if header.Version == FormatVersion {
+		records := make([]*record, 0, len(lines))
+		for _, line := range lines {
+			var rec record
+			err := json.Unmarshal(line, &rec)
+			if err != nil {
+				return header, nil, err
+			}
+			records = append(records, &rec)
+		}
+		joinHTTPMessages(records)
+
+		return header, records, nil
+	}
+
+	raw := rawCassetteFile{CassetteHeader: header}
+	for _, line := range lines {
+		var rec map[string]interface{}
+		err := json.Unmarshal(line, &rec)
+		if err != nil {
+			return header, nil, err
+		}
+		raw.Records = append(raw.Records, rec)
+	}
+
+	return migrateCassetteFile(raw, unmarshalRawJSON)
+}
+
+func unmarshalRawJSON(raw []map[string]interface{}) ([]*record, error) {
+	var records []*record
+	err := json.Unmarshal(jsonMarshal(raw), &records)
+
+	return records, err
+}
+
+// marshalCassetteFile encodes a whole cassette file.
+func marshalCassetteFile(format Format, header CassetteHeader, records []*record) []byte {
+	switch format {
+	case FormatJSON:
+		return marshalCassetteJSON(header, records)
+	case FormatJSONL:
+		return append(marshalJSONLine(header), marshalAppendable(format, records, header.Canonical)...)
+	}
+
+	return marshalCassetteYAML(header, records)
+}
+
+// marshalFileStart encodes the beginning of a cassette file the records are appended to.
+func marshalFileStart(format Format, header CassetteHeader) []byte {
+	switch format {
+	case FormatJSON:
+		return marshalCassetteJSON(header, nil)
+	case FormatJSONL:
+		return marshalJSONLine(header)
+	}
+
+	return []byte(marshalCassetteHeader(header))
+}
+
+// marshalAppendable encodes records to be appended to a cassette file.
+// JSON files can't be appended to.
+func marshalAppendable(format Format, records []*record, canonical bool) []byte {
+	if format != FormatJSONL {
+		return marshalRecords(records, canonical)
+	}
+
+	var buf bytes.Buffer
+	for _, rec := range jsonRecords(sortRecords(records, canonical)) {
+		buf.Write(marshalJSONLine(rec))
+	}
+
+	return buf.Bytes()
+}
+
+func marshalCassetteJSON(header CassetteHeader, records []*record) []byte {
+	file := cassetteFile{
+		CassetteHeader: header,
+		Records:        jsonRecords(sortRecords(records, header.Canonical)),
+	}
+
+	dump, _ := json.MarshalIndent(file, "", "  ")
+	return append(dump, '\n')
+}
+
+func marshalJSONLine(value interface{}) []byte {
+	return append(jsonMarshal(value), '\n')
+}
+
+func jsonMarshal(value interface{}) []byte {
+	bytes, _ := json.Marshal(value)
+	return bytes
+}
+
+// jsonRecords makes panic values decoded from YAML encodable to JSON.
+func jsonRecords(records []*record) []*record {
+	converted := make([]*record, 0, len(records))
+	for _, rec := range records {
+		if rec.Panic != nil {
+			copied := *rec
+			copied.Panic = jsonValue(rec.Panic)
+			rec = &copied
+		}
+		converted = append(converted, rec)
+	}
+
+	return converted
+}
+
+func jsonValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			converted[fmt.Sprint(key)] = jsonValue(item)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, 0, len(value))
+		for _, item := range value {
+			converted = append(converted, jsonValue(item))
+		}
+		return converted
+	}
+
+	return value
+}
+
+func (c *Cassette) Format() Format {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.format
+}
+
+// SetFormat sets the format of the cassette file.
+// It should be set before the file is created with WithFile.
+func (c *Cassette) SetFormat(format Format) *Cassette {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.format = format
+
+	return c
+}
+
+// MarshalTo encodes the cassette in the format.
+func (c *Cassette) MarshalTo(format Format) []byte {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return marshalCassetteFile(format, c.header, c.resolved(c.records()))
+}
+
+type truncateSeeker interface {
+	Truncate(size int64) error
+	io.Seeker
+}
+
+// writeRecords writes the added records to the cassette file.
+// JSON files are rewritten as a whole.
+func (c *Cassette) writeRecords(records []*record) error {
+	records, err := c.stored(records)
+	if err != nil {
+		return err
+	}
+
+	if c.format != FormatJSON {
+		return c.write(string(marshalAppendable(c.format, records, c.header.Canonical)))
+	}
+
+	return c.rewriteWritten()
+}
+
+// rewriteChanged writes the records right away after some of them were changed or removed.
+// Cassettes loaded from files are rewritten, recorded ones are rewritten in place.
+func (c *Cassette) rewriteChanged() error {
+	if c.writer == nil || c.writer.ReadOnly() {
+		return c.rewrite()
+	}
+
+	return c.rewriteWritten()
+}
+
+// rewriteWritten writes all the records in place of the written ones.
+// Writers that can't be rewritten in place are rewritten on Finalize.
+func (c *Cassette) rewriteWritten() error {
+	if c.writer == nil || c.writer.ReadOnly() {
+		return nil
+	}
+
+	w, ok := c.writer.(truncateSeeker)
+	if !ok {
+		c.dirty = true
+		return nil
+	}
+
+	dump, err := c.marshal()
+	if err != nil {
+		return err
+	}
+
+	err = w.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = w.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	return c.write(string(dump))
+}
@@ -0,0 +1,103 @@
+package playback
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+)
+
+var errStoreReadOnly = errors.New("Store is read-only")
+
+// FSStore is a read-only store of the cassettes of a file system, like an embed.FS.
+// Cassette directories are listed and loaded by the name of the directory.
+type FSStore struct {
+	fsys fs.FS
+}
+
+func NewFSStore(fsys fs.FS) *FSStore {
+	return &FSStore{fsys: fsys}
+}
+
+func (s *FSStore) FS() fs.FS {
+	return s.fsys
+}
+
+func (*FSStore) Save(string, []byte) error {
+	return errStoreReadOnly
+}
+
+func (s *FSStore) Load(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, errStoreName
+	}
+
+	dump, err := fs.ReadFile(s.fsys, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrStoreNotFound
+	}
+
+	return dump, err
+}
+
+func (s *FSStore) List() ([]string, error) {
+	var names []string
+	err := fs.WalkDir(s.fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			if _, err := openCassetteDirFS(s.fsys, name); err == nil && name != "." {
+				names = append(names, name)
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if entry.Type().IsRegular() {
+			names = append(names, name)
+		}
+		return nil
+	})
+
+	return names, err
+}
+
+func (*FSStore) Delete(string) error {
+	return errStoreReadOnly
+}
+
+// newCassetteFromFS loads a cassette file or directory of the file system.
+// The cassette is read-only.
+func newCassetteFromFS(p *Playback, fsys fs.FS, name string) (*Cassette, error) {
+	name = path.Clean(name)
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var dir *cassetteDir
+	index := name
+	if info.IsDir() {
+		dir, err = openCassetteDirFS(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		index = dir.index
+	}
+
+	dump, err := fs.ReadFile(fsys, index)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := newCassetteFromYAML(p, dump)
+	if err != nil {
+		return c, err
+	}
+
+	c.dir = dir
+	c.writer = newNilNamed(PathTypeFS, name)
+
+	return c, nil
+}
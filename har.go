@@ -0,0 +1,395 @@
+package playback
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const harVersion = "1.2"
+
+var errHARKindUnsupported = errors.New("HAR entries can be converted to http and http_request records only")
+
+var curlURLRegexp = regexp.MustCompile(`'(https?://[^']*)'`)
+
+// HAR is an HTTP Archive as exported by browser dev tools and proxies.
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+}
+
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type HAREntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+
+	// Kind keeps the kind of the exported record, it is a custom HAR field.
+	Kind RecordKind `json:"_kind,omitempty"`
+	// Error keeps the error of the exported record, it is a custom HAR field.
+	Error string `json:"_error,omitempty"`
+}
+
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type HARContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type HARTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// AddFromHAR converts the entries of a HAR dump into records of the kind:
+// KindHTTP records are replayed by HTTPTransport, KindHTTPRequest records by NewHTTPMiddleware.
+// With an empty kind the kind kept in an exported HAR is used, KindHTTP by default.
+func (c *Cassette) AddFromHAR(dump []byte, kind RecordKind) error {
+	if kind != "" && kind != KindHTTP && kind != KindHTTPRequest {
+		return errHARKindUnsupported
+	}
+
+	var har HAR
+	err := json.Unmarshal(dump, &har)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range har.Log.Entries {
+		rec, err := c.recordFromHAREntry(entry, kind)
+		if err != nil {
+			return err
+		}
+
+		err = c.Add(rec)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Cassette) recordFromHAREntry(entry HAREntry, kind RecordKind) (*record, error) {
+	if kind == "" {
+		kind = entry.Kind
+	}
+	if kind == "" {
+		kind = KindHTTP
+	}
+	if kind != KindHTTP && kind != KindHTTPRequest {
+		return nil, errHARKindUnsupported
+	}
+
+	req, err := entry.Request.httpRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	var rec *record
+	if kind == KindHTTPRequest {
+		rec = c.buildHTTPRecord(req)
+	} else {
+		rec = (&HTTPRecorder{cassette: c}).newRecord(req)
+	}
+
+	if entry.Error != "" {
+		rec.Err = RecordError{errors.New(entry.Error)}
+	} else {
+		res, err := entry.Response.httpResponse(req)
+		if err != nil {
+			return nil, err
+		}
+		rec.Response = httpDumpResponse(res)
+	}
+
+	rec.CreatedAt = entry.StartedDateTime.UTC().Truncate(time.Second)
+	rec.Duration = time.Duration(entry.Time * float64(time.Millisecond))
+
+	return rec, nil
+}
+
+func (r HARRequest) httpRequest() (*http.Request, error) {
+	var body []byte
+	if r.PostData != nil {
+		body = []byte(r.PostData.Text)
+	}
+
+	req, err := http.NewRequest(r.Method, r.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		req.Body, req.ContentLength = nil, 0
+	}
+
+	for _, header := range r.Headers {
+		switch {
+		case strings.HasPrefix(header.Name, ":"):
+		case strings.EqualFold(header.Name, "Host"):
+			req.Host = header.Value
+		case strings.EqualFold(header.Name, "Content-Length"):
+		default:
+			req.Header.Add(header.Name, header.Value)
+		}
+	}
+	if r.PostData != nil && r.PostData.MimeType != "" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", r.PostData.MimeType)
+	}
+
+	return req, nil
+}
+
+func (r HARResponse) httpResponse(req *http.Request) (*http.Response, error) {
+	body := []byte(r.Content.Text)
+	if r.Content.Encoding == "base64" {
+		var err error
+		body, err = base64.StdEncoding.DecodeString(r.Content.Text)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	statusText := r.StatusText
+	if statusText == "" {
+		statusText = http.StatusText(r.Status)
+	}
+
+	res := &http.Response{
+		Status:        strconv.Itoa(r.Status) + " " + statusText,
+		StatusCode:    r.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header, len(r.Headers)),
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+
+	for _, header := range r.Headers {
+		switch {
+		case strings.HasPrefix(header.Name, ":"):
+		case strings.EqualFold(header.Name, "Content-Length"),
+			strings.EqualFold(header.Name, "Content-Encoding"),
+			strings.EqualFold(header.Name, "Transfer-Encoding"):
+			// The HAR content is decoded
+		default:
+			res.Header.Add(header.Name, header.Value)
+		}
+	}
+	if r.Content.MimeType != "" && res.Header.Get("Content-Type") == "" {
+		res.Header.Set("Content-Type", r.Content.MimeType)
+	}
+
+	return res, nil
+}
+
+// MarshalToHAR exports the http and http_request records of the cassette in HAR format.
+func (c *Cassette) MarshalToHAR() ([]byte, error) {
+	c.mu.RLock()
+	records := sortRecords(c.resolved(c.records()), false)
+	c.mu.RUnlock()
+
+	har := HAR{Log: HARLog{
+		Version: harVersion,
+		Creator: HARCreator{Name: "playback", Version: LibraryVersion},
+		Entries: make([]HAREntry, 0, len(records)),
+	}}
+	for _, rec := range records {
+		if rec.Kind != KindHTTP && rec.Kind != KindHTTPRequest {
+			continue
+		}
+
+		entry, err := harEntryFromRecord(rec)
+		if err != nil {
+			return nil, err
+		}
+		har.Log.Entries = append(har.Log.Entries, entry)
+	}
+
+	return json.MarshalIndent(har, "", "  ")
+}
+
+func harEntryFromRecord(rec *record) (HAREntry, error) {
+	req, err := httpReadRequest(rec.Request)
+	if err != nil {
+		return HAREntry{}, err
+	}
+
+	requestBody, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return HAREntry{}, err
+	}
+
+	wait := float64(rec.Duration) / float64(time.Millisecond)
+	entry := HAREntry{
+		StartedDateTime: rec.CreatedAt,
+		Time:            wait,
+		Request: HARRequest{
+			Method:      req.Method,
+			URL:         recordURL(rec, req),
+			HTTPVersion: req.Proto,
+			Cookies:     []HARNameValue{},
+			Headers:     harHeaders(req.Header, req.Host),
+			QueryString: harQueryString(req.URL.Query()),
+			HeadersSize: -1,
+			BodySize:    len(requestBody),
+		},
+		Timings: HARTimings{Wait: wait},
+		Kind:    rec.Kind,
+	}
+	if len(requestBody) > 0 {
+		entry.Request.PostData = &HARPostData{
+			MimeType: req.Header.Get("Content-Type"),
+			Text:     string(requestBody),
+		}
+	}
+
+	if rec.Err.error != nil {
+		entry.Error = rec.Err.Error()
+	}
+	if rec.Response == "" {
+		entry.Response = HARResponse{Cookies: []HARNameValue{}, Headers: []HARNameValue{}, HeadersSize: -1, BodySize: -1}
+		return entry, nil
+	}
+
+	res, err := httpReadResponse(rec.Response, req)
+	if err != nil {
+		return HAREntry{}, err
+	}
+	defer res.Body.Close()
+
+	responseBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return HAREntry{}, err
+	}
+
+	entry.Response = HARResponse{
+		Status:      res.StatusCode,
+		StatusText:  strings.TrimPrefix(res.Status, strconv.Itoa(res.StatusCode)+" "),
+		HTTPVersion: res.Proto,
+		Cookies:     []HARNameValue{},
+		Headers:     harHeaders(res.Header, ""),
+		Content: HARContent{
+			Size:     len(responseBody),
+			MimeType: res.Header.Get("Content-Type"),
+		},
+		RedirectURL: res.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    len(responseBody),
+	}
+	if utf8.Valid(responseBody) {
+		entry.Response.Content.Text = string(responseBody)
+	} else {
+		entry.Response.Content.Text = base64.StdEncoding.EncodeToString(responseBody)
+		entry.Response.Content.Encoding = "base64"
+	}
+
+	return entry, nil
+}
+
+// recordURL restores the absolute URL of a recorded request.
+func recordURL(rec *record, req *http.Request) string {
+	if match := curlURLRegexp.FindStringSubmatch(rec.RequestMeta); match != nil {
+		return match[1]
+	}
+
+	u := *req.URL
+	u.Scheme, u.Host = "http", req.Host
+
+	return u.String()
+}
+
+func harHeaders(header http.Header, host string) []HARNameValue {
+	values := make([]HARNameValue, 0, len(header)+1)
+	if host != "" {
+		values = append(values, HARNameValue{Name: "Host", Value: host})
+	}
+	for _, name := range sortedKeys(header) {
+		for _, value := range header[name] {
+			values = append(values, HARNameValue{Name: name, Value: value})
+		}
+	}
+
+	return values
+}
+
+func harQueryString(query url.Values) []HARNameValue {
+	values := make([]HARNameValue, 0, len(query))
+	for _, name := range sortedKeys(query) {
+		for _, value := range query[name] {
+			values = append(values, HARNameValue{Name: name, Value: value})
+		}
+	}
+
+	return values
+}
+
+func sortedKeys(values map[string][]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
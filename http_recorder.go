@@ -1,8 +1,10 @@
 package playback
 
 import (
+	"context"
 	"net/http"
 	"net/http/httputil"
+	"time"
 )
 
 type HTTPRecorder struct {
@@ -73,7 +75,9 @@ func (r *HTTPRecorder) record(req *http.Request) (*http.Response, error) {
 
 	r.rec.RecordRequest()
 
+	start := time.Now()
 	res, err := r.call(req)
+	r.rec.Duration = time.Since(start)
 
 	r.RecordResponse(res, err)
 	r.rec.PanicIfHas()
@@ -100,6 +104,34 @@ func (r *HTTPRecorder) RecordResponse(res *http.Response, err error) {
 	r.rec.Err = RecordError{err}
 
 	r.rec.Record()
+	r.rec.cassette.validateOpenAPI(r.rec)
+}
+
+func (r *HTTPRecorder) lookup() *record {
+	return r.rec
+}
+
+func (r *HTTPRecorder) callContext() context.Context {
+	return r.req.Context()
+}
+
+func (r *HTTPRecorder) fail(err error) {
+	r.res, r.err = nil, err
+}
+
+func (r *HTTPRecorder) respond(res *http.Response) {
+	res.Request = r.req
+	r.res, r.err = res, nil
+}
+
+// truncate breaks the body of the replayed response and reports whether there was one.
+func (r *HTTPRecorder) truncate() bool {
+	if r.res == nil || r.res.Body == nil {
+		return false
+	}
+
+	r.res.Body = newTruncatedBody(r.res.Body)
+	return true
 }
 
 func (r *HTTPRecorder) newRecord(req *http.Request) *record {
@@ -35,6 +35,8 @@ func httpDeleteHeaders(res *http.Response) *http.Response {
 	res.Header.Del(HeaderMode)
 	res.Header.Del(HeaderSuccess)
 	res.Header.Del(HeaderCassetteID)
+	res.Header.Del(HeaderCassetteName)
+	res.Header.Del(HeaderReport)
 
 	return res
 }
@@ -0,0 +1,83 @@
+package playback
+
+import (
+	"time"
+)
+
+// Latency defines how the recorded duration of a call is reproduced in playback.
+// Factor scales the recorded duration: zero replays instantly and 1 reproduces
+// the original latency. A positive Max caps the delay.
+type Latency struct {
+	Factor float64
+	Max    time.Duration
+}
+
+// LatencyOriginal reproduces recorded durations as is.
+var LatencyOriginal = Latency{Factor: 1}
+
+func (l Latency) delay(duration time.Duration) time.Duration {
+	delay := time.Duration(float64(duration) * l.Factor)
+	if l.Max > 0 && delay > l.Max {
+		delay = l.Max
+	}
+
+	return delay
+}
+
+func (c *Cassette) Latency() Latency {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.latency
+}
+
+func (c *Cassette) SetLatency(latency Latency) *Cassette {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.latency = latency
+
+	return c
+}
+
+func (c *Cassette) playbackRecorder(recorder Recorder) error {
+	err := recorder.Playback()
+	if err == ErrPlaybackFailed {
+		return err
+	}
+
+	if errLatency := c.reproduceLatency(recorder); errLatency != nil {
+		return errLatency
+	}
+
+	return err
+}
+
+// reproduceLatency waits for the recorded duration of the replayed call.
+// If the call context is done meanwhile, the call fails with the context error.
+func (c *Cassette) reproduceLatency(recorder Recorder) error {
+	outcome, ok := recorder.(outcomeRecorder)
+	if !ok || outcome.lookup() == nil {
+		return nil
+	}
+
+	return c.sleep(outcome, c.Latency().delay(outcome.lookup().Duration))
+}
+
+func (c *Cassette) sleep(outcome outcomeRecorder, delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	ctx := outcome.callContext()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		outcome.fail(ctx.Err())
+		return ctx.Err()
+	}
+}
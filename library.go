@@ -0,0 +1,208 @@
+package playback
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+var (
+	errLibraryNotSet   = errors.New("Cassette library is not set")
+	errScenarioUnknown = errors.New("Scenario is not in the cassette library")
+)
+
+// library is a set of curated cassettes, the scenarios, kept in a store and addressed by name.
+type library struct {
+	store Store
+	// names maps scenario names to the names of the cassettes in the store.
+	names map[string]string
+}
+
+// loadLibrary lists and checks the cassettes of the store.
+// A scenario is named after its cassette without the format extension.
+func loadLibrary(store Store) (*library, error) {
+	storeNames, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	lib := &library{
+		store: store,
+		names: make(map[string]string, len(storeNames)),
+	}
+	for _, storeName := range storeNames {
+		name, ok := scenarioName(storeName)
+		if !ok {
+			continue
+		}
+		if other, ok := lib.names[name]; ok {
+			return nil, fmt.Errorf("Scenario %s is kept both in %s and %s", name, other, storeName)
+		}
+
+		dump, err := loadStoreDump(store, storeName)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", storeName, err)
+		}
+		_, _, _, err = unmarshalCassetteFile(dump)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", storeName, err)
+		}
+
+		lib.names[name] = storeName
+	}
+
+	return lib, nil
+}
+
+// scenarioName strips the format and compression extensions from the cassette name.
+// Pending side files and files of other formats aren't scenarios.
+func scenarioName(storeName string) (string, bool) {
+	name := strings.TrimSuffix(storeName, compressedSuffix)
+	if strings.HasSuffix(strings.TrimSuffix(name, path.Ext(name)), pendingSuffix) {
+		return "", false
+	}
+
+	switch path.Ext(name) {
+	case ".yml", ".yaml", ".json", ".jsonl":
+		return strings.TrimSuffix(name, path.Ext(name)), true
+	case "":
+		// Cassette directories
+		return name, true
+	}
+
+	return "", false
+}
+
+// loadStoreDump loads the cassette file, or the index of a cassette directory of a file system store.
+func loadStoreDump(store Store, name string) ([]byte, error) {
+	if fsys, dir, ok := storeCassetteDir(store, name); ok {
+		return fs.ReadFile(fsys, dir.index)
+	}
+
+	return store.Load(name)
+}
+
+// storeCassetteDir opens the cassette directory kept under the name by a DirStore or an FSStore.
+// The bodies of the directory are loaded from the returned file system.
+func storeCassetteDir(store Store, name string) (fs.FS, *cassetteDir, bool) {
+	var fsys fs.FS
+	switch s := store.(type) {
+	case *FSStore:
+		fsys = s.FS()
+	case *DirStore:
+		if _, err := s.path(name); err != nil {
+			return nil, nil, false
+		}
+		fsys = os.DirFS(s.Root())
+	default:
+		return nil, nil, false
+	}
+
+	dir, err := openCassetteDirFS(fsys, name)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	return fsys, dir, true
+}
+
+func (l *library) scenarios() []string {
+	names := make([]string, 0, len(l.names))
+	for name := range l.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// SetLibrary loads the cassette library from the store, like a DirStore or an FSStore.
+// Scenarios are selected by name with the x-playback-name header or gRPC metadata key.
+// The library isn't changed if a cassette of the store can't be loaded.
+func (p *Playback) SetLibrary(store Store) error {
+	lib, err := loadLibrary(store)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.library = lib
+
+	return nil
+}
+
+// ReloadLibrary loads the cassette library from its store again.
+func (p *Playback) ReloadLibrary() error {
+	p.mu.RLock()
+	lib := p.library
+	p.mu.RUnlock()
+
+	if lib == nil {
+		return errLibraryNotSet
+	}
+
+	return p.SetLibrary(lib.store)
+}
+
+// Library returns the names of the scenarios of the cassette library.
+func (p *Playback) Library() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.library == nil {
+		return nil
+	}
+
+	return p.library.scenarios()
+}
+
+// CassetteByName loads a new cassette of the scenario in playback mode.
+func (p *Playback) CassetteByName(name string) (*Cassette, error) {
+	p.mu.RLock()
+	lib := p.library
+	p.mu.RUnlock()
+
+	if lib == nil {
+		return nil, errLibraryNotSet
+	}
+
+	storeName, ok := lib.names[name]
+	if !ok {
+		return nil, errScenarioUnknown
+	}
+
+	c, err := loadCassetteFromStore(p, lib.store, storeName)
+	if err != nil {
+		return c, err
+	}
+
+	c.name = name
+
+	return c, nil
+}
+
+func (p *Playback) hasScenario(name string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.library == nil {
+		return false
+	}
+	_, ok := p.library.names[name]
+
+	return ok
+}
+
+// Name returns the scenario name of a cassette of the library.
+func (c *Cassette) Name() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.name
+}
@@ -0,0 +1,272 @@
+package playback
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// HTTPMatch is a rule matching outgoing requests to a record when no record has the exact request key.
+// Set conditions must all hold. Records imported from go-vcr and WireMock get the rules of their source.
+// URL is compared to the request URI, or also to the host if it has a scheme.
+type HTTPMatch struct {
+	Method         string                `yaml:"method,omitempty" json:"method,omitempty"`
+	URL            string                `yaml:"url,omitempty" json:"url,omitempty"`
+	URLPath        string                `yaml:"url_path,omitempty" json:"url_path,omitempty"`
+	URLPattern     string                `yaml:"url_pattern,omitempty" json:"url_pattern,omitempty"`
+	URLPathPattern string                `yaml:"url_path_pattern,omitempty" json:"url_path_pattern,omitempty"`
+	Headers        map[string]ValueMatch `yaml:"headers,omitempty" json:"headers,omitempty"`
+	Query          map[string]ValueMatch `yaml:"query,omitempty" json:"query,omitempty"`
+	Body           []ValueMatch          `yaml:"body,omitempty" json:"body,omitempty"`
+	// Priority orders matching records, the lowest number wins. Records of equal priority are tried in ID order.
+	Priority int `yaml:"priority,omitempty" json:"priority,omitempty"`
+
+	patterns patterns
+}
+
+// ValueMatch is a condition on a header, a query parameter or a body.
+type ValueMatch struct {
+	EqualTo         string `yaml:"equal_to,omitempty" json:"equal_to,omitempty"`
+	CaseInsensitive bool   `yaml:"case_insensitive,omitempty" json:"case_insensitive,omitempty"`
+	Contains        string `yaml:"contains,omitempty" json:"contains,omitempty"`
+	Matches         string `yaml:"matches,omitempty" json:"matches,omitempty"`
+	DoesNotMatch    string `yaml:"does_not_match,omitempty" json:"does_not_match,omitempty"`
+	EqualToJSON     string `yaml:"equal_to_json,omitempty" json:"equal_to_json,omitempty"`
+	Absent          bool   `yaml:"absent,omitempty" json:"absent,omitempty"`
+}
+
+func (m *HTTPMatch) matches(req *http.Request, body string) bool {
+	if m.Method != "" && m.Method != "ANY" && !strings.EqualFold(m.Method, req.Method) {
+		return false
+	}
+
+	uri, path := req.URL.RequestURI(), req.URL.Path
+	if m.URL != "" && !matchesURL(m.URL, req) {
+		return false
+	}
+	if m.URLPath != "" && m.URLPath != path {
+		return false
+	}
+	if m.URLPattern != "" && !m.patterns.match(m.URLPattern, uri) {
+		return false
+	}
+	if m.URLPathPattern != "" && !m.patterns.match(m.URLPathPattern, path) {
+		return false
+	}
+
+	for name, match := range m.Headers {
+		if !match.matchesAny(req.Header[http.CanonicalHeaderKey(name)], m.patterns) {
+			return false
+		}
+	}
+
+	query := req.URL.Query()
+	for name, match := range m.Query {
+		if !match.matchesAny(query[name], m.patterns) {
+			return false
+		}
+	}
+
+	for _, match := range m.Body {
+		if !match.matches(body, m.patterns) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// compile compiles the regular expressions of the rule once, so matching doesn't.
+func (m *HTTPMatch) compile() {
+	m.patterns = make(patterns)
+	m.patterns.add(m.URLPattern)
+	m.patterns.add(m.URLPathPattern)
+
+	for _, match := range m.Headers {
+		m.patterns.add(match.Matches)
+		m.patterns.add(match.DoesNotMatch)
+	}
+	for _, match := range m.Query {
+		m.patterns.add(match.Matches)
+		m.patterns.add(match.DoesNotMatch)
+	}
+	for _, match := range m.Body {
+		m.patterns.add(match.Matches)
+		m.patterns.add(match.DoesNotMatch)
+	}
+}
+
+// matchesURL compares the request URI and, if the rule URL has a scheme, the host.
+// Requests are matched from their dump, which doesn't keep the scheme.
+func matchesURL(rawURL string, req *http.Request) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || !u.IsAbs() {
+		return rawURL == req.URL.RequestURI()
+	}
+
+	host := req.URL.Host
+	if host == "" {
+		host = req.Host
+	}
+
+	return strings.EqualFold(u.Host, host) && u.RequestURI() == req.URL.RequestURI()
+}
+
+func (m ValueMatch) matchesAny(values []string, patterns patterns) bool {
+	if m.Absent {
+		return len(values) == 0
+	}
+
+	for _, value := range values {
+		if m.matches(value, patterns) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m ValueMatch) matches(value string, patterns patterns) bool {
+	if m.EqualTo != "" {
+		if m.CaseInsensitive && !strings.EqualFold(m.EqualTo, value) {
+			return false
+		}
+		if !m.CaseInsensitive && m.EqualTo != value {
+			return false
+		}
+	}
+	if m.Contains != "" && !strings.Contains(value, m.Contains) {
+		return false
+	}
+	if m.Matches != "" && !patterns.match(m.Matches, value) {
+		return false
+	}
+	if m.DoesNotMatch != "" && patterns.match(m.DoesNotMatch, value) {
+		return false
+	}
+	if m.EqualToJSON != "" && !equalJSON(m.EqualToJSON, value) {
+		return false
+	}
+
+	return true
+}
+
+// patterns keeps the compiled regular expressions of a match rule by their source.
+// An invalid expression is kept as nil and matches nothing.
+type patterns map[string]*regexp.Regexp
+
+func (p patterns) add(pattern string) {
+	if pattern == "" {
+		return
+	}
+	if _, ok := p[pattern]; ok {
+		return
+	}
+
+	p[pattern] = compilePattern(pattern)
+}
+
+// match reports whether the whole value matches the regular expression.
+// A rule that wasn't compiled compiles the expression on the spot.
+func (p patterns) match(pattern, value string) bool {
+	re, ok := p[pattern]
+	if !ok {
+		re = compilePattern(pattern)
+	}
+
+	return re != nil && re.MatchString(value)
+}
+
+func compilePattern(pattern string) *regexp.Regexp {
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil
+	}
+
+	return re
+}
+
+func equalJSON(expected, got string) bool {
+	var expectedValue, gotValue interface{}
+	if json.Unmarshal([]byte(expected), &expectedValue) != nil || json.Unmarshal([]byte(got), &gotValue) != nil {
+		return false
+	}
+
+	return reflect.DeepEqual(expectedValue, gotValue)
+}
+
+// getByRule picks the record with a match rule accepting the request
+// among the records the track cursors point at.
+func (c *Cassette) getByRule(lookup *record) (*record, *track) {
+	if lookup.Kind != KindHTTP || lookup.Request == "" {
+		return nil, nil
+	}
+
+	var candidates []*record
+	tracks := make(map[*record]*track)
+	for _, track := range c.tracks[lookup.Kind] {
+		if track.cursor >= len(track.records) {
+			continue
+		}
+
+		rec := track.records[track.cursor]
+		if rec.Match != nil {
+			candidates = append(candidates, rec)
+			tracks[rec] = track
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Match.Priority != candidates[j].Match.Priority {
+			return candidates[i].Match.Priority < candidates[j].Match.Priority
+		}
+		return candidates[i].ID < candidates[j].ID
+	})
+
+	req, err := httpReadRequest(lookup.Request)
+	if err != nil {
+		return nil, nil
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+
+	for _, rec := range candidates {
+		if rec.Match.matches(req, string(body)) {
+			return rec, tracks[rec]
+		}
+	}
+
+	return nil, nil
+}
+
+// hasNext reports whether a record with the key is left to replay.
+func (c *Cassette) hasNext(kind RecordKind, key string) bool {
+	track := c.tracks[kind][key]
+	return track != nil && track.cursor < len(track.records)
+}
+
+// SetHTTPMatch sets the match rule of the records with the key.
+func (c *Cassette) SetHTTPMatch(key string, match *HTTPMatch) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.tracks[KindHTTP] == nil || c.tracks[KindHTTP][key] == nil {
+		return errCassetteGetFailed
+	}
+
+	if match != nil {
+		match.compile()
+	}
+	for _, rec := range c.tracks[KindHTTP][key].records {
+		rec.Match = match
+	}
+
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"os"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
@@ -13,6 +14,7 @@ const (
 	HeaderCassetteID       = "x-playback-id"
 	HeaderCassettePathType = "x-playback-path-type"
 	HeaderCassettePathName = "x-playback-path-name"
+	HeaderCassetteName     = "x-playback-name"
 	HeaderMode             = "x-playback-mode"
 	HeaderSuccess          = "x-playback-success"
 )
@@ -37,6 +39,9 @@ func (p *Playback) NewHTTPMiddleware(next http.Handler) http.Handler {
 		if pathName := cassette.PathName(); pathName != "" {
 			rw.Header().Set(HeaderCassettePathName, pathName)
 		}
+		if name := cassette.Name(); name != "" {
+			rw.Header().Set(HeaderCassetteName, name)
+		}
 		rw.Header().Set(HeaderMode, string(mode))
 		rw.Header().Set(HeaderCassetteID, cassette.ID)
 
@@ -47,8 +52,10 @@ func (p *Playback) NewHTTPMiddleware(next http.Handler) http.Handler {
 		if mode == ModeRecord {
 			rw.Header().Set(HeaderSuccess, "true")
 			cassette.SetHTTPResponse(req, res)
+			cassette.Sync()
 		} else if mode == ModePlayback {
 			rw.Header().Set(HeaderSuccess, fmt.Sprintf("%t", cassette.IsHTTPResponseCorrect(res) && cassette.IsPlaybackSucceeded()))
+			rw.Header().Set(HeaderReport, reportPath(cassette.ID))
 
 			rw.Flush()
 		}
@@ -70,6 +77,7 @@ func (p *Playback) NewGRPCMiddleware() grpc.UnaryServerInterceptor {
 
 		if mode == ModeRecord {
 			cassette.SetGRPCResponse(res)
+			cassette.Sync()
 		}
 
 		md := metadata.Pairs(
@@ -82,10 +90,14 @@ func (p *Playback) NewGRPCMiddleware() grpc.UnaryServerInterceptor {
 		if pathName := cassette.PathName(); pathName != "" {
 			md.Set(HeaderCassettePathName, pathName)
 		}
+		if name := cassette.Name(); name != "" {
+			md.Set(HeaderCassetteName, name)
+		}
 		if mode == ModeRecord {
 			md.Set(HeaderSuccess, "true")
 		} else if mode == ModePlayback {
 			md.Set(HeaderSuccess, fmt.Sprintf("%t", cassette.IsGRPCResponseCorrect(res) && cassette.IsPlaybackSucceeded()))
+			md.Set(HeaderReport, reportPath(cassette.ID))
 		}
 
 		grpc.SendHeader(ctx, md)
@@ -95,7 +107,7 @@ func (p *Playback) NewGRPCMiddleware() grpc.UnaryServerInterceptor {
 }
 
 func (p *Playback) incomingCassetteFromHTTPRequest(req *http.Request) *Cassette {
-	return p.incomingCassette(req.Context(), req.Header.Get(HeaderCassetteID), req.Header.Get(HeaderMode), req.Header.Get(HeaderCassettePathType), req.Header.Get(HeaderCassettePathName))
+	return p.incomingCassette(req.Context(), httpCredentials(req), req.Header.Get(HeaderCassetteID), req.Header.Get(HeaderCassetteName), req.Header.Get(HeaderMode), req.Header.Get(HeaderCassettePathType), req.Header.Get(HeaderCassettePathName))
 }
 
 type MD struct {
@@ -115,21 +127,36 @@ func (p *Playback) incomingCassetteFromGRPCContext(ctx context.Context) *Cassett
 	md, _ := metadata.FromIncomingContext(ctx)
 	meta := MD{md}
 
-	return p.incomingCassette(ctx, meta.Get(HeaderCassetteID), meta.Get(HeaderMode), meta.Get(HeaderCassettePathType), meta.Get(HeaderCassettePathName))
+	return p.incomingCassette(ctx, grpcCredentials(ctx), meta.Get(HeaderCassetteID), meta.Get(HeaderCassetteName), meta.Get(HeaderMode), meta.Get(HeaderCassettePathType), meta.Get(HeaderCassettePathName))
 }
 
-func (p *Playback) incomingCassette(ctx context.Context, cassetteID, mode, pathType, pathName string) *Cassette {
+func (p *Playback) incomingCassette(ctx context.Context, creds Credentials, cassetteID, name, mode, pathType, pathName string) *Cassette {
+	// The headers of callers without the permissions are ignored
+	for _, permission := range p.headersPermissions(cassetteID, name, mode, pathType, pathName) {
+		if p.authorize(creds, permission) != nil {
+			cassetteID, name, mode, pathType, pathName = "", "", "", "", ""
+			break
+		}
+	}
+
 	cassette := CassetteFromContext(ctx)
 	if cassette == nil {
 		if cassetteID != "" {
-			cassette = p.cassettes[cassetteID]
+			cassette = p.Get(cassetteID)
 			if cassette != nil {
 				cassette.SetMode(ModePlayback).Rewind()
 			}
 		}
 	}
+	if cassette == nil && name != "" {
+		cassette, _ = p.CassetteByName(name)
+	}
 	if cassette == nil && PathType(pathType) == PathTypeFile {
-		cassette, _ = p.CassetteFromFile(pathName)
+		if p.HeaderFileAccess() {
+			cassette, _ = p.CassetteFromFile(pathName)
+		}
+	} else if cassette == nil && p.Store(PathType(pathType)) != nil && pathName != "" {
+		cassette, _ = p.CassetteFromStore(PathType(pathType), pathName)
 	}
 	if cassette == nil {
 		cassette, _ = p.NewCassette()
@@ -137,10 +164,45 @@ func (p *Playback) incomingCassette(ctx context.Context, cassetteID, mode, pathT
 			cassette.SetMode(ModeRecord)
 
 			if PathType(pathType) == PathTypeFile {
-				cassette.WithFile()
+				if p.HeaderFileAccess() {
+					cassette.WithFile()
+				}
+			} else if p.Store(PathType(pathType)) != nil {
+				cassette.WithStore(PathType(pathType), pathName)
 			}
 		}
 	}
 
 	return cassette
 }
+
+// selectsCassette tells whether the headers select an existing cassette to load.
+func (p *Playback) selectsCassette(cassetteID, name, pathType, pathName string) bool {
+	if cassetteID != "" && p.Get(cassetteID) != nil {
+		return true
+	}
+	if name != "" && p.hasScenario(name) {
+		return true
+	}
+	if pathName == "" {
+		return false
+	}
+
+	if PathType(pathType) == PathTypeFile {
+		if !p.HeaderFileAccess() {
+			return false
+		}
+
+		path, err := p.cassettePath(pathName)
+		if err != nil {
+			return false
+		}
+		_, err = os.Stat(path)
+
+		return err == nil
+	}
+
+	store := p.Store(PathType(pathType))
+
+	return store != nil && storeHas(store, pathName)
+}
@@ -0,0 +1,222 @@
+package playback
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// MissAction defines what happens in playback mode when no record matches a call.
+type MissAction string
+
+const (
+	// MissFail fails the call with ErrPlaybackFailed.
+	MissFail MissAction = ""
+	// MissRespond answers an HTTP call with a synthetic response of MissPolicy.StatusCode.
+	MissRespond MissAction = "respond"
+	// MissError fails the call with MissPolicy.Err.
+	MissError MissAction = "error"
+	// MissPanic panics with a diagnostic message.
+	MissPanic MissAction = "panic"
+	// MissCallThrough calls the real dependency without recording.
+	MissCallThrough MissAction = "call_through"
+	// MissBlock waits until a matching record is added to the cassette,
+	// for instance through the service API, for at most MissPolicy.Timeout.
+	// Calls without a context, such as Cassette.Result, wait for at most
+	// DefaultMissBlockTimeout when MissPolicy.Timeout isn't set.
+	MissBlock MissAction = "block"
+	// MissSynthesize answers an HTTP call with a response built from the examples or schemas
+	// of MissPolicy.OpenAPI, or of the cassette OpenAPI document, and adds it to the cassette
+	// as a generated record.
+	MissSynthesize MissAction = "synthesize"
+)
+
+// DefaultMissBlockTimeout limits MissBlock waits of calls that can't be cancelled.
+const DefaultMissBlockTimeout = time.Minute
+
+type MissPolicy struct {
+	Action     MissAction
+	StatusCode int
+	Err        error
+	Timeout    time.Duration
+	OpenAPI    *OpenAPI
+}
+
+// outcomeRecorder is implemented by the built-in recorders
+// so the cassette can inspect and override the outcome of a call.
+type outcomeRecorder interface {
+	Recorder
+	lookup() *record
+	callContext() context.Context
+	fail(err error)
+}
+
+type httpOutcomeRecorder interface {
+	outcomeRecorder
+	respond(res *http.Response)
+	truncate() bool
+}
+
+func (c *Cassette) MissPolicy(kind RecordKind) MissPolicy {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.missPolicies[kind]
+}
+
+func (c *Cassette) SetMissPolicy(kind RecordKind, policy MissPolicy) *Cassette {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.missPolicies[kind] = policy
+
+	return c
+}
+
+func (c *Cassette) recordAdded() <-chan struct{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.added
+}
+
+func (c *Cassette) notifyRecordAdded() {
+	close(c.added)
+	c.added = make(chan struct{})
+}
+
+func (c *Cassette) setError(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.err = err
+}
+
+func (c *Cassette) replay(recorder Recorder) error {
+	added := c.recordAdded()
+	errBefore := c.Error()
+
+	err := c.playbackRecorder(recorder)
+	if err != ErrPlaybackFailed {
+		return c.injectFault(recorder, err)
+	}
+
+	outcome, ok := recorder.(outcomeRecorder)
+	if ok && outcome.lookup() != nil && outcome.lookup().matched != nil {
+		// A record was consumed but doesn't fit the call: a mismatch, not a miss.
+		return err
+	}
+
+	c.emitMiss(recorder)
+	if !ok || outcome.lookup() == nil {
+		return err
+	}
+
+	rec := outcome.lookup()
+	c.addMiss(rec)
+	policy := c.MissPolicy(rec.Kind)
+
+	switch policy.Action {
+	case MissRespond:
+		httpOutcome, ok := outcome.(httpOutcomeRecorder)
+		if !ok {
+			return err
+		}
+
+		httpOutcome.respond(newMissResponse(policy.StatusCode, c.missDiagnostic(rec)))
+		return nil
+
+	case MissError:
+		if policy.Err == nil {
+			return err
+		}
+
+		outcome.fail(policy.Err)
+		return policy.Err
+
+	case MissPanic:
+		panic(c.missDiagnostic(rec))
+
+	case MissCallThrough:
+		return recorder.Call()
+
+	case MissBlock:
+		return c.waitForRecord(outcome, policy.Timeout, added, errBefore)
+
+	case MissSynthesize:
+		httpOutcome, ok := outcome.(httpOutcomeRecorder)
+		spec := policy.OpenAPI
+		if spec == nil {
+			spec = c.OpenAPI()
+		}
+		if !ok || spec == nil {
+			return err
+		}
+
+		return c.synthesizeMiss(httpOutcome, rec, spec, errBefore)
+	}
+
+	return err
+}
+
+func (c *Cassette) waitForRecord(recorder outcomeRecorder, timeout time.Duration, added <-chan struct{}, errBefore error) error {
+	ctx := recorder.callContext()
+
+	if timeout <= 0 && ctx.Done() == nil {
+		timeout = DefaultMissBlockTimeout
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		expired = timer.C
+	}
+
+	for {
+		select {
+		case <-added:
+		case <-ctx.Done():
+			recorder.fail(ctx.Err())
+			return ctx.Err()
+		case <-expired:
+			return ErrPlaybackFailed
+		}
+
+		added = c.recordAdded()
+		err := c.playbackRecorder(recorder)
+		if err != ErrPlaybackFailed {
+			c.setError(errBefore)
+			return c.injectFault(recorder, err)
+		}
+	}
+}
+
+func (c *Cassette) missDiagnostic(rec *record) string {
+	return fmt.Sprintf("Playback miss in cassette %s: no %s record matches key %q", c.ID, rec.Kind, rec.Key)
+}
+
+func newMissResponse(statusCode int, diagnostic string) *http.Response {
+	if statusCode == 0 {
+		statusCode = http.StatusNotImplemented
+	}
+
+	header := make(http.Header)
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode: statusCode,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewBufferString(diagnostic)),
+		ContentLength: int64(len(diagnostic)),
+	}
+}
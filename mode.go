@@ -18,6 +18,7 @@ const (
 	ModeRecord                  Mode = "record"
 	ModePlaybackOrRecord        Mode = "playback_or_record"
 	ModePlaybackSuccessOrRecord Mode = "playback_success_or_record"
+	ModeRefreshStale            Mode = "refresh_stale"
 )
 
 type SyncMode string
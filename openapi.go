@@ -0,0 +1,635 @@
+package playback
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const openAPISchemaRefPrefix = "#/components/schemas/"
+
+var (
+	errOpenAPIInvalid     = errors.New("OpenAPI document is invalid")
+	errOpenAPIUnsupported = errors.New("OpenAPI version is unsupported")
+)
+
+// OpenAPI is the subset of an OpenAPI 3 document used to validate and synthesize http records.
+// Local references are resolved, external ones are not supported.
+type OpenAPI struct {
+	OpenAPI    string                      `json:"openapi"`
+	Servers    []OpenAPIServer             `json:"servers,omitempty"`
+	Paths      map[string]*OpenAPIPathItem `json:"paths"`
+	Components OpenAPIComponents           `json:"components,omitempty"`
+}
+
+type OpenAPIServer struct {
+	URL string `json:"url"`
+}
+
+type OpenAPIComponents struct {
+	Schemas map[string]*OpenAPISchema `json:"schemas,omitempty"`
+}
+
+type OpenAPIPathItem struct {
+	Parameters []*OpenAPIParameter `json:"parameters,omitempty"`
+	Get        *OpenAPIOperation   `json:"get,omitempty"`
+	Put        *OpenAPIOperation   `json:"put,omitempty"`
+	Post       *OpenAPIOperation   `json:"post,omitempty"`
+	Delete     *OpenAPIOperation   `json:"delete,omitempty"`
+	Options    *OpenAPIOperation   `json:"options,omitempty"`
+	Head       *OpenAPIOperation   `json:"head,omitempty"`
+	Patch      *OpenAPIOperation   `json:"patch,omitempty"`
+	Trace      *OpenAPIOperation   `json:"trace,omitempty"`
+}
+
+type OpenAPIOperation struct {
+	OperationID string                      `json:"operationId,omitempty"`
+	Parameters  []*OpenAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*OpenAPIResponse `json:"responses"`
+}
+
+type OpenAPIParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required,omitempty"`
+	Schema   *OpenAPISchema `json:"schema,omitempty"`
+}
+
+type OpenAPIRequestBody struct {
+	Required bool                         `json:"required,omitempty"`
+	Content  map[string]*OpenAPIMediaType `json:"content,omitempty"`
+}
+
+type OpenAPIResponse struct {
+	Description string                       `json:"description,omitempty"`
+	Headers     map[string]*OpenAPIHeader    `json:"headers,omitempty"`
+	Content     map[string]*OpenAPIMediaType `json:"content,omitempty"`
+}
+
+type OpenAPIHeader struct {
+	Required bool           `json:"required,omitempty"`
+	Schema   *OpenAPISchema `json:"schema,omitempty"`
+}
+
+type OpenAPIMediaType struct {
+	Schema   *OpenAPISchema             `json:"schema,omitempty"`
+	Example  interface{}                `json:"example,omitempty"`
+	Examples map[string]*OpenAPIExample `json:"examples,omitempty"`
+}
+
+type OpenAPIExample struct {
+	Value interface{} `json:"value,omitempty"`
+}
+
+// OpenAPIViolation is a difference between an http record and the OpenAPI document.
+type OpenAPIViolation struct {
+	ID      uint64 `json:"id"`
+	Key     string `json:"key"`
+	Message string `json:"message"`
+}
+
+func (v OpenAPIViolation) String() string {
+	return fmt.Sprintf("record %d %s: %s", v.ID, v.Key, v.Message)
+}
+
+// LoadOpenAPI reads an OpenAPI 3 document in YAML or JSON.
+func LoadOpenAPI(filename string) (*OpenAPI, error) {
+	dump, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseOpenAPI(dump)
+}
+
+// ParseOpenAPI parses an OpenAPI 3 document in YAML or JSON.
+func ParseOpenAPI(dump []byte) (*OpenAPI, error) {
+	var document interface{}
+	err := yaml.Unmarshal(dump, &document)
+	if err != nil {
+		return nil, err
+	}
+
+	root, ok := jsonValue(document).(map[string]interface{})
+	if !ok {
+		return nil, errOpenAPIInvalid
+	}
+
+	resolved, err := json.Marshal(resolveOpenAPIRefs(root, root, nil))
+	if err != nil {
+		return nil, err
+	}
+
+	var spec OpenAPI
+	err = json.Unmarshal(resolved, &spec)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", errOpenAPIInvalid, err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		return nil, fmt.Errorf("%s: %q", errOpenAPIUnsupported, spec.OpenAPI)
+	}
+
+	return &spec, nil
+}
+
+// resolveOpenAPIRefs inlines the local references other than schema ones.
+// Schemas may be recursive, so they are resolved on use.
+func resolveOpenAPIRefs(value interface{}, root map[string]interface{}, seen map[string]bool) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if ref, ok := value["$ref"].(string); ok && strings.HasPrefix(ref, "#/") && !strings.HasPrefix(ref, openAPISchemaRefPrefix) {
+			target := lookupJSONPointer(root, ref[1:])
+			if target == nil || seen[ref] {
+				return value
+			}
+
+			refs := map[string]bool{ref: true}
+			for seenRef := range seen {
+				refs[seenRef] = true
+			}
+			return resolveOpenAPIRefs(target, root, refs)
+		}
+
+		resolved := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			resolved[key] = resolveOpenAPIRefs(item, root, seen)
+		}
+		return resolved
+	case []interface{}:
+		resolved := make([]interface{}, 0, len(value))
+		for _, item := range value {
+			resolved = append(resolved, resolveOpenAPIRefs(item, root, seen))
+		}
+		return resolved
+	}
+
+	return value
+}
+
+func lookupJSONPointer(document interface{}, pointer string) interface{} {
+	for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		if unescaped, err := url.PathUnescape(token); err == nil {
+			token = unescaped
+		}
+
+		switch node := document.(type) {
+		case map[string]interface{}:
+			document = node[token]
+		case []interface{}:
+			index, err := strconv.Atoi(token)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil
+			}
+			document = node[index]
+		default:
+			return nil
+		}
+	}
+
+	return document
+}
+
+func (item *OpenAPIPathItem) operation(method string) *OpenAPIOperation {
+	switch strings.ToUpper(method) {
+	case http.MethodGet:
+		return item.Get
+	case http.MethodPut:
+		return item.Put
+	case http.MethodPost:
+		return item.Post
+	case http.MethodDelete:
+		return item.Delete
+	case http.MethodOptions:
+		return item.Options
+	case http.MethodHead:
+		return item.Head
+	case http.MethodPatch:
+		return item.Patch
+	case http.MethodTrace:
+		return item.Trace
+	}
+
+	return nil
+}
+
+// parameters merges the parameters of the path item and the operation, the latter take precedence.
+func (item *OpenAPIPathItem) parameters(op *OpenAPIOperation) []*OpenAPIParameter {
+	var parameters []*OpenAPIParameter
+	overridden := make(map[string]bool, len(op.Parameters))
+	for _, parameter := range op.Parameters {
+		overridden[parameter.In+":"+parameter.Name] = true
+		parameters = append(parameters, parameter)
+	}
+	for _, parameter := range item.Parameters {
+		if !overridden[parameter.In+":"+parameter.Name] {
+			parameters = append(parameters, parameter)
+		}
+	}
+
+	return parameters
+}
+
+// findPath returns the path template matching the request path with the values of path parameters.
+// Concrete paths are preferred over templated ones.
+func (o *OpenAPI) findPath(path string) (string, *OpenAPIPathItem, map[string]string) {
+	segments := splitURLPath(path)
+
+	bases := [][]string{nil}
+	for _, server := range o.Servers {
+		serverURL, err := url.Parse(server.URL)
+		if err == nil && strings.Trim(serverURL.Path, "/") != "" {
+			bases = append(bases, splitURLPath(serverURL.Path))
+		}
+	}
+
+	var (
+		found      string
+		foundItem  *OpenAPIPathItem
+		foundVars  map[string]string
+		foundScore = -1
+	)
+	for _, base := range bases {
+		if len(base) > len(segments) {
+			continue
+		}
+		if _, _, ok := matchPathTemplate(base, segments[:len(base)]); !ok {
+			continue
+		}
+
+		for _, template := range sortedPathTemplates(o.Paths) {
+			vars, score, ok := matchPathTemplate(splitURLPath(template), segments[len(base):])
+			if ok && score > foundScore {
+				found, foundItem, foundVars, foundScore = template, o.Paths[template], vars, score
+			}
+		}
+	}
+
+	return found, foundItem, foundVars
+}
+
+func sortedPathTemplates(paths map[string]*OpenAPIPathItem) []string {
+	templates := make([]string, 0, len(paths))
+	for template := range paths {
+		templates = append(templates, template)
+	}
+	sort.Strings(templates)
+
+	return templates
+}
+
+func splitURLPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+
+	return strings.Split(path, "/")
+}
+
+var pathTemplateVariableRegexp = regexp.MustCompile(`\{([^}]+)\}`)
+
+// matchPathTemplate matches the path segments against the template ones
+// and returns the variables and the number of literal segments.
+func matchPathTemplate(template, segments []string) (map[string]string, int, bool) {
+	if len(template) != len(segments) {
+		return nil, 0, false
+	}
+
+	vars := make(map[string]string)
+	literal := 0
+	for i, part := range template {
+		if !strings.Contains(part, "{") {
+			if part != segments[i] {
+				return nil, 0, false
+			}
+			literal++
+			continue
+		}
+
+		names := pathTemplateVariableRegexp.FindAllStringSubmatch(part, -1)
+		pattern, last := "^", 0
+		for _, loc := range pathTemplateVariableRegexp.FindAllStringIndex(part, -1) {
+			pattern += regexp.QuoteMeta(part[last:loc[0]]) + "(.+?)"
+			last = loc[1]
+		}
+		pattern += regexp.QuoteMeta(part[last:]) + "$"
+
+		values := regexp.MustCompile(pattern).FindStringSubmatch(segments[i])
+		if values == nil {
+			return nil, 0, false
+		}
+		for j, name := range names {
+			vars[name[1]] = values[j+1]
+		}
+	}
+
+	return vars, literal, true
+}
+
+// validateHTTP checks the dumps of an http request and its response.
+func (o *OpenAPI) validateHTTP(requestDump, responseDump string) []string {
+	req, err := httpReadRequest(requestDump)
+	if err != nil {
+		return []string{fmt.Sprintf("request can't be read: %s", err)}
+	}
+
+	template, item, vars := o.findPath(req.URL.Path)
+	if item == nil {
+		return []string{fmt.Sprintf("path %s is not documented", req.URL.Path)}
+	}
+
+	op := item.operation(req.Method)
+	if op == nil {
+		return []string{fmt.Sprintf("method %s is not documented for %s", req.Method, template)}
+	}
+
+	var violations []string
+	violations = append(violations, o.validateParameters(item.parameters(op), req, vars)...)
+
+	requestBody, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return append(violations, fmt.Sprintf("request body can't be read: %s", err))
+	}
+	violations = append(violations, o.validateRequestBody(op.RequestBody, req.Header.Get("Content-Type"), requestBody)...)
+
+	if responseDump == "" {
+		return violations
+	}
+
+	res, err := httpReadResponse(responseDump, req)
+	if err != nil {
+		return append(violations, fmt.Sprintf("response can't be read: %s", err))
+	}
+	defer res.Body.Close()
+
+	responseBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return append(violations, fmt.Sprintf("response body can't be read: %s", err))
+	}
+
+	return append(violations, o.validateResponse(op, res, responseBody)...)
+}
+
+func (o *OpenAPI) validateParameters(parameters []*OpenAPIParameter, req *http.Request, vars map[string]string) []string {
+	var violations []string
+	for _, parameter := range parameters {
+		var values []string
+		switch parameter.In {
+		case "path":
+			if value, ok := vars[parameter.Name]; ok {
+				values = []string{value}
+			}
+		case "query":
+			values = req.URL.Query()[parameter.Name]
+		case "header":
+			values = req.Header[http.CanonicalHeaderKey(parameter.Name)]
+		case "cookie":
+			if cookie, err := req.Cookie(parameter.Name); err == nil {
+				values = []string{cookie.Value}
+			}
+		}
+
+		location := fmt.Sprintf("%s parameter %s", parameter.In, parameter.Name)
+		if len(values) == 0 {
+			if parameter.Required || parameter.In == "path" {
+				violations = append(violations, location+" is required")
+			}
+			continue
+		}
+
+		violations = append(violations, o.validateSchema(parameter.Schema, o.coerceParameter(parameter.Schema, values), location)...)
+	}
+
+	return violations
+}
+
+// coerceParameter converts the string values of a parameter into the JSON value the schema describes.
+func (o *OpenAPI) coerceParameter(schema *OpenAPISchema, values []string) interface{} {
+	schema = o.resolveSchema(schema)
+	if schema == nil {
+		return values[0]
+	}
+
+	if schema.Type.has("array") {
+		if len(values) == 1 {
+			values = strings.Split(values[0], ",")
+		}
+		items := make([]interface{}, 0, len(values))
+		for _, value := range values {
+			items = append(items, o.coerceParameter(schema.Items, []string{value}))
+		}
+		return items
+	}
+
+	value := values[0]
+	switch {
+	case schema.Type.has("integer") || schema.Type.has("number"):
+		if number, err := strconv.ParseFloat(value, 64); err == nil {
+			return number
+		}
+	case schema.Type.has("boolean"):
+		if boolean, err := strconv.ParseBool(value); err == nil {
+			return boolean
+		}
+	}
+
+	return value
+}
+
+func (o *OpenAPI) validateRequestBody(requestBody *OpenAPIRequestBody, contentType string, body []byte) []string {
+	if requestBody == nil {
+		return nil
+	}
+	if len(body) == 0 {
+		if requestBody.Required {
+			return []string{"request body is required"}
+		}
+		return nil
+	}
+
+	return o.validateContent(requestBody.Content, contentType, body, "request body")
+}
+
+func (o *OpenAPI) validateResponse(op *OpenAPIOperation, res *http.Response, body []byte) []string {
+	response := op.response(res.StatusCode)
+	if response == nil {
+		return []string{fmt.Sprintf("status %d is not documented", res.StatusCode)}
+	}
+
+	var violations []string
+	for name, header := range response.Headers {
+		values := res.Header[http.CanonicalHeaderKey(name)]
+		location := "response header " + name
+		if len(values) == 0 {
+			if header.Required {
+				violations = append(violations, location+" is required")
+			}
+			continue
+		}
+		violations = append(violations, o.validateSchema(header.Schema, o.coerceParameter(header.Schema, values), location)...)
+	}
+
+	if len(body) == 0 || len(response.Content) == 0 {
+		return violations
+	}
+
+	return append(violations, o.validateContent(response.Content, res.Header.Get("Content-Type"), body, "response body")...)
+}
+
+// response returns the response documented for the status code, a range like 2XX, or the default one.
+func (op *OpenAPIOperation) response(statusCode int) *OpenAPIResponse {
+	code := strconv.Itoa(statusCode)
+	for _, key := range []string{code, code[:1] + "XX", code[:1] + "xx", "default"} {
+		if response := op.Responses[key]; response != nil {
+			return response
+		}
+	}
+
+	return nil
+}
+
+func (o *OpenAPI) validateContent(content map[string]*OpenAPIMediaType, contentType string, body []byte, location string) []string {
+	if len(content) == 0 {
+		return nil
+	}
+
+	mediaType, media := findMediaType(content, contentType)
+	if media == nil {
+		return []string{fmt.Sprintf("%s content type %q is not documented", location, contentType)}
+	}
+	if media.Schema == nil || !isJSONMediaType(mediaType) {
+		return nil
+	}
+
+	var value interface{}
+	err := json.Unmarshal(body, &value)
+	if err != nil {
+		return []string{fmt.Sprintf("%s is not valid JSON: %s", location, err)}
+	}
+
+	return o.validateSchema(media.Schema, value, location+" $")
+}
+
+// findMediaType returns the media type of the body and the content entry describing it:
+// exact, type/* or */*. Bodies without a content type are taken as JSON.
+func findMediaType(content map[string]*OpenAPIMediaType, contentType string) (string, *OpenAPIMediaType) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "application/json"
+	}
+
+	candidates := []string{mediaType}
+	if i := strings.Index(mediaType, "/"); i >= 0 {
+		candidates = append(candidates, mediaType[:i]+"/*")
+	}
+	candidates = append(candidates, "*/*")
+
+	for _, candidate := range candidates {
+		for key, media := range content {
+			if strings.EqualFold(strings.TrimSpace(strings.Split(key, ";")[0]), candidate) {
+				return mediaType, media
+			}
+		}
+	}
+
+	return mediaType, nil
+}
+
+func isJSONMediaType(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// ValidateOpenAPI checks the http records of the cassette against the OpenAPI document:
+// path, method, parameters, status code and JSON bodies.
+func (c *Cassette) ValidateOpenAPI(spec *OpenAPI) []OpenAPIViolation {
+	c.mu.RLock()
+	records := sortRecords(c.resolved(c.records()), false)
+	c.mu.RUnlock()
+
+	var violations []OpenAPIViolation
+	for _, rec := range records {
+		violations = append(violations, spec.validateRecord(rec)...)
+	}
+
+	return violations
+}
+
+func (o *OpenAPI) validateRecord(rec *record) []OpenAPIViolation {
+	if rec.Kind != KindHTTP || rec.Request == "" {
+		return nil
+	}
+
+	var violations []OpenAPIViolation
+	for _, message := range o.validateHTTP(rec.Request, rec.Response) {
+		violations = append(violations, OpenAPIViolation{ID: rec.ID, Key: rec.Key, Message: message})
+	}
+
+	return violations
+}
+
+func (c *Cassette) OpenAPI() *OpenAPI {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.openAPI
+}
+
+// SetOpenAPI makes the cassette check every http record written by HTTPRecorder.RecordResponse
+// against the OpenAPI document. The violations are kept in OpenAPIViolations.
+func (c *Cassette) SetOpenAPI(spec *OpenAPI) *Cassette {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.openAPI = spec
+
+	return c
+}
+
+// OpenAPIViolations returns the violations found in the records written since SetOpenAPI.
+func (c *Cassette) OpenAPIViolations() []OpenAPIViolation {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return append([]OpenAPIViolation(nil), c.openAPIViolations...)
+}
+
+func (c *Cassette) validateOpenAPI(rec *record) {
+	c.mu.RLock()
+	spec := c.openAPI
+	var resolved []*record
+	if spec != nil {
+		resolved = c.resolved([]*record{rec})
+	}
+	c.mu.RUnlock()
+
+	if spec == nil {
+		return
+	}
+
+	violations := spec.validateRecord(resolved[0])
+	if len(violations) == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.openAPIViolations = append(c.openAPIViolations, violations...)
+	if c.debug {
+		for _, violation := range violations {
+			c.logger.Debugf("OpenAPI violation: %s\n", violation)
+		}
+	}
+}
@@ -0,0 +1,349 @@
+package playback
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// OpenAPISchema is the subset of the JSON schema dialect of OpenAPI 3 checked by the validator.
+type OpenAPISchema struct {
+	Ref         string        `json:"$ref,omitempty"`
+	Type        openAPITypes  `json:"type,omitempty"`
+	Format      string        `json:"format,omitempty"`
+	Nullable    bool          `json:"nullable,omitempty"`
+	Enum        []interface{} `json:"enum,omitempty"`
+	Example     interface{}   `json:"example,omitempty"`
+	Examples    []interface{} `json:"examples,omitempty"`
+	Default     interface{}   `json:"default,omitempty"`
+	ReadOnly    bool          `json:"readOnly,omitempty"`
+	WriteOnly   bool          `json:"writeOnly,omitempty"`
+	Description string        `json:"description,omitempty"`
+
+	Properties           map[string]*OpenAPISchema `json:"properties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	AdditionalProperties json.RawMessage           `json:"additionalProperties,omitempty"`
+	MinProperties        *int                      `json:"minProperties,omitempty"`
+	MaxProperties        *int                      `json:"maxProperties,omitempty"`
+
+	Items       *OpenAPISchema `json:"items,omitempty"`
+	MinItems    *int           `json:"minItems,omitempty"`
+	MaxItems    *int           `json:"maxItems,omitempty"`
+	UniqueItems bool           `json:"uniqueItems,omitempty"`
+
+	MinLength *int   `json:"minLength,omitempty"`
+	MaxLength *int   `json:"maxLength,omitempty"`
+	Pattern   string `json:"pattern,omitempty"`
+
+	Minimum *float64 `json:"minimum,omitempty"`
+	Maximum *float64 `json:"maximum,omitempty"`
+	// ExclusiveMinimum and ExclusiveMaximum are booleans in OpenAPI 3.0 and numbers in 3.1.
+	ExclusiveMinimum json.RawMessage `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum json.RawMessage `json:"exclusiveMaximum,omitempty"`
+	MultipleOf       *float64        `json:"multipleOf,omitempty"`
+
+	AllOf []*OpenAPISchema `json:"allOf,omitempty"`
+	AnyOf []*OpenAPISchema `json:"anyOf,omitempty"`
+	OneOf []*OpenAPISchema `json:"oneOf,omitempty"`
+	Not   *OpenAPISchema   `json:"not,omitempty"`
+}
+
+// openAPITypes is the type of a schema: a single name in OpenAPI 3.0, a list of names in 3.1.
+type openAPITypes []string
+
+func (t *openAPITypes) UnmarshalJSON(dump []byte) error {
+	var name string
+	if json.Unmarshal(dump, &name) == nil {
+		*t = openAPITypes{name}
+		return nil
+	}
+
+	var names []string
+	err := json.Unmarshal(dump, &names)
+	*t = names
+
+	return err
+}
+
+func (t openAPITypes) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+
+	return json.Marshal([]string(t))
+}
+
+func (t openAPITypes) has(name string) bool {
+	for _, item := range t {
+		if item == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// additional returns whether properties not listed are allowed and the schema they should match.
+func (s *OpenAPISchema) additional() (bool, *OpenAPISchema) {
+	if len(s.AdditionalProperties) == 0 {
+		return true, nil
+	}
+
+	var allowed bool
+	if json.Unmarshal(s.AdditionalProperties, &allowed) == nil {
+		return allowed, nil
+	}
+
+	var schema OpenAPISchema
+	if json.Unmarshal(s.AdditionalProperties, &schema) != nil {
+		return true, nil
+	}
+
+	return true, &schema
+}
+
+// exclusiveBound returns the limit of an exclusive keyword and whether it is exclusive.
+func exclusiveBound(keyword json.RawMessage, inclusive *float64) (*float64, bool) {
+	if len(keyword) == 0 {
+		return inclusive, false
+	}
+
+	var exclusive bool
+	if json.Unmarshal(keyword, &exclusive) == nil {
+		return inclusive, exclusive
+	}
+
+	var limit float64
+	if json.Unmarshal(keyword, &limit) == nil {
+		return &limit, true
+	}
+
+	return inclusive, false
+}
+
+// resolveSchema follows the references to component schemas.
+func (o *OpenAPI) resolveSchema(schema *OpenAPISchema) *OpenAPISchema {
+	for depth := 0; schema != nil && schema.Ref != "" && depth < 32; depth++ {
+		if !strings.HasPrefix(schema.Ref, openAPISchemaRefPrefix) {
+			return nil
+		}
+		schema = o.Components.Schemas[strings.TrimPrefix(schema.Ref, openAPISchemaRefPrefix)]
+	}
+
+	if schema != nil && schema.Ref != "" {
+		return nil
+	}
+
+	return schema
+}
+
+// validateSchema checks a JSON value decoded by encoding/json against the schema.
+func (o *OpenAPI) validateSchema(schema *OpenAPISchema, value interface{}, location string) []string {
+	if schema == nil {
+		return nil
+	}
+
+	resolved := o.resolveSchema(schema)
+	if resolved == nil {
+		return []string{fmt.Sprintf("%s: unresolved schema %s", location, schema.Ref)}
+	}
+	schema = resolved
+
+	var violations []string
+	fail := func(format string, args ...interface{}) {
+		violations = append(violations, location+": "+fmt.Sprintf(format, args...))
+	}
+
+	for _, sub := range schema.AllOf {
+		violations = append(violations, o.validateSchema(sub, value, location)...)
+	}
+	if len(schema.AnyOf) > 0 && o.countMatching(schema.AnyOf, value) == 0 {
+		fail("matches none of anyOf")
+	}
+	if len(schema.OneOf) > 0 {
+		if n := o.countMatching(schema.OneOf, value); n != 1 {
+			fail("matches %d of oneOf, expected 1", n)
+		}
+	}
+	if schema.Not != nil && len(o.validateSchema(schema.Not, value, location)) == 0 {
+		fail("matches the not schema")
+	}
+
+	if value == nil {
+		if len(schema.Type) > 0 && !schema.Nullable && !schema.Type.has("null") {
+			fail("expected %s, got null", strings.Join(schema.Type, " or "))
+		}
+		return violations
+	}
+
+	if len(schema.Type) > 0 && !schema.Type.has(jsonTypeOf(value)) && !(schema.Type.has("number") && jsonTypeOf(value) == "integer") {
+		fail("expected %s, got %s", strings.Join(schema.Type, " or "), jsonTypeOf(value))
+		return violations
+	}
+
+	if len(schema.Enum) > 0 && !containsJSONValue(schema.Enum, value) {
+		fail("%v is not one of %v", value, schema.Enum)
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		violations = append(violations, o.validateObject(schema, value, location)...)
+	case []interface{}:
+		violations = append(violations, o.validateArray(schema, value, location)...)
+	case string:
+		length := utf8.RuneCountInString(value)
+		if schema.MinLength != nil && length < *schema.MinLength {
+			fail("length %d is less than %d", length, *schema.MinLength)
+		}
+		if schema.MaxLength != nil && length > *schema.MaxLength {
+			fail("length %d is greater than %d", length, *schema.MaxLength)
+		}
+		if schema.Pattern != "" {
+			if pattern, err := regexp.Compile(schema.Pattern); err == nil && !pattern.MatchString(value) {
+				fail("%q doesn't match %s", value, schema.Pattern)
+			}
+		}
+	case float64:
+		if minimum, exclusive := exclusiveBound(schema.ExclusiveMinimum, schema.Minimum); minimum != nil {
+			if value < *minimum || exclusive && value == *minimum {
+				fail("%v is less than the minimum %v", value, *minimum)
+			}
+		}
+		if maximum, exclusive := exclusiveBound(schema.ExclusiveMaximum, schema.Maximum); maximum != nil {
+			if value > *maximum || exclusive && value == *maximum {
+				fail("%v is greater than the maximum %v", value, *maximum)
+			}
+		}
+		if schema.MultipleOf != nil && *schema.MultipleOf != 0 {
+			if quotient := value / *schema.MultipleOf; quotient != math.Trunc(quotient) {
+				fail("%v is not a multiple of %v", value, *schema.MultipleOf)
+			}
+		}
+	}
+
+	return violations
+}
+
+func (o *OpenAPI) validateObject(schema *OpenAPISchema, object map[string]interface{}, location string) []string {
+	var violations []string
+
+	for _, name := range schema.Required {
+		if _, ok := object[name]; !ok {
+			violations = append(violations, fmt.Sprintf("%s: property %s is required", location, name))
+		}
+	}
+	if schema.MinProperties != nil && len(object) < *schema.MinProperties {
+		violations = append(violations, fmt.Sprintf("%s: has less than %d properties", location, *schema.MinProperties))
+	}
+	if schema.MaxProperties != nil && len(object) > *schema.MaxProperties {
+		violations = append(violations, fmt.Sprintf("%s: has more than %d properties", location, *schema.MaxProperties))
+	}
+
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	allowed, additional := schema.additional()
+	for _, name := range names {
+		propertyLocation := location + "." + name
+		if property, ok := schema.Properties[name]; ok {
+			violations = append(violations, o.validateSchema(property, object[name], propertyLocation)...)
+			continue
+		}
+		if !allowed {
+			violations = append(violations, fmt.Sprintf("%s: property is not allowed", propertyLocation))
+			continue
+		}
+		violations = append(violations, o.validateSchema(additional, object[name], propertyLocation)...)
+	}
+
+	return violations
+}
+
+func (o *OpenAPI) validateArray(schema *OpenAPISchema, items []interface{}, location string) []string {
+	var violations []string
+
+	if schema.MinItems != nil && len(items) < *schema.MinItems {
+		violations = append(violations, fmt.Sprintf("%s: has less than %d items", location, *schema.MinItems))
+	}
+	if schema.MaxItems != nil && len(items) > *schema.MaxItems {
+		violations = append(violations, fmt.Sprintf("%s: has more than %d items", location, *schema.MaxItems))
+	}
+	if schema.UniqueItems {
+		for i := range items {
+			if containsJSONValue(items[:i], items[i]) {
+				violations = append(violations, fmt.Sprintf("%s: items aren't unique", location))
+				break
+			}
+		}
+	}
+
+	for i, item := range items {
+		violations = append(violations, o.validateSchema(schema.Items, item, fmt.Sprintf("%s[%d]", location, i))...)
+	}
+
+	return violations
+}
+
+func (o *OpenAPI) countMatching(schemas []*OpenAPISchema, value interface{}) int {
+	n := 0
+	for _, schema := range schemas {
+		if len(o.validateSchema(schema, value, "")) == 0 {
+			n++
+		}
+	}
+
+	return n
+}
+
+func jsonTypeOf(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if value == math.Trunc(value) && !math.IsInf(value, 0) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+
+	return fmt.Sprintf("%T", value)
+}
+
+func containsJSONValue(values []interface{}, value interface{}) bool {
+	for _, item := range values {
+		if reflect.DeepEqual(jsonNormalize(item), jsonNormalize(value)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// jsonNormalize makes values decoded from YAML and JSON comparable.
+func jsonNormalize(value interface{}) interface{} {
+	dump, err := json.Marshal(jsonValue(value))
+	if err != nil {
+		return value
+	}
+
+	var normalized interface{}
+	json.Unmarshal(dump, &normalized)
+
+	return normalized
+}
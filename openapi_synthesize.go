@@ -0,0 +1,316 @@
+package playback
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var errOpenAPIOperationNotFound = errors.New("OpenAPI operation not found")
+
+// synthesize builds a response to the request from the examples or the schema
+// of the first successful response documented for the operation.
+func (o *OpenAPI) synthesize(req *http.Request) (*http.Response, error) {
+	_, item, _ := o.findPath(req.URL.Path)
+	if item == nil {
+		return nil, errOpenAPIOperationNotFound
+	}
+	op := item.operation(req.Method)
+	if op == nil {
+		return nil, errOpenAPIOperationNotFound
+	}
+
+	statusCode, response := op.successResponse()
+	if response == nil {
+		return nil, errOpenAPIOperationNotFound
+	}
+
+	header := make(http.Header)
+	var body []byte
+	if mediaType, media := response.mediaType(req.Header.Get("Accept")); media != nil {
+		header.Set("Content-Type", mediaType)
+		body = o.synthesizeBody(mediaType, media)
+	}
+
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode: statusCode,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Request:    req,
+
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}, nil
+}
+
+// successResponse returns the lowest documented 2xx response, the default one or the first documented one.
+func (op *OpenAPIOperation) successResponse() (int, *OpenAPIResponse) {
+	keys := make([]string, 0, len(op.Responses))
+	for key := range op.Responses {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if strings.HasPrefix(key, "2") {
+			return responseStatusCode(key), op.Responses[key]
+		}
+	}
+	if response := op.Responses["default"]; response != nil {
+		return http.StatusOK, response
+	}
+	for _, key := range keys {
+		return responseStatusCode(key), op.Responses[key]
+	}
+
+	return 0, nil
+}
+
+func responseStatusCode(key string) int {
+	if statusCode, err := strconv.Atoi(key); err == nil {
+		return statusCode
+	}
+	if statusCode, err := strconv.Atoi(key[:1] + "00"); err == nil {
+		return statusCode
+	}
+
+	return http.StatusOK
+}
+
+// mediaType picks the content of the response accepted by the client, JSON otherwise.
+func (r *OpenAPIResponse) mediaType(accept string) (string, *OpenAPIMediaType) {
+	if len(r.Content) == 0 {
+		return "", nil
+	}
+
+	for _, accepted := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil || mediaType == "*/*" {
+			continue
+		}
+		if media := r.Content[mediaType]; media != nil {
+			return mediaType, media
+		}
+	}
+
+	if media := r.Content["application/json"]; media != nil {
+		return "application/json", media
+	}
+
+	mediaTypes := make([]string, 0, len(r.Content))
+	for mediaType := range r.Content {
+		mediaTypes = append(mediaTypes, mediaType)
+	}
+	sort.Strings(mediaTypes)
+
+	return mediaTypes[0], r.Content[mediaTypes[0]]
+}
+
+func (o *OpenAPI) synthesizeBody(mediaType string, media *OpenAPIMediaType) []byte {
+	value := media.Example
+	if value == nil && len(media.Examples) > 0 {
+		names := make([]string, 0, len(media.Examples))
+		for name := range media.Examples {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		value = media.Examples[names[0]].Value
+	}
+	if value == nil {
+		value = o.exampleOf(media.Schema, nil)
+	}
+
+	if text, ok := value.(string); ok && !isJSONMediaType(mediaType) {
+		return []byte(text)
+	}
+
+	body, _ := json.Marshal(value)
+	return body
+}
+
+// exampleOf builds a value valid against the schema, preferring the values given in the document.
+// Recursive references end with empty arrays and omitted properties.
+func (o *OpenAPI) exampleOf(schema *OpenAPISchema, expanding map[string]bool) interface{} {
+	if schema != nil && schema.Ref != "" {
+		if expanding[schema.Ref] {
+			return nil
+		}
+
+		refs := map[string]bool{schema.Ref: true}
+		for ref := range expanding {
+			refs[ref] = true
+		}
+		expanding = refs
+	}
+
+	schema = o.resolveSchema(schema)
+	if schema == nil {
+		return nil
+	}
+
+	switch {
+	case schema.Example != nil:
+		return schema.Example
+	case len(schema.Examples) > 0:
+		return schema.Examples[0]
+	case schema.Default != nil:
+		return schema.Default
+	case len(schema.Enum) > 0:
+		return schema.Enum[0]
+	case len(schema.AllOf) > 0:
+		merged := make(map[string]interface{})
+		for _, sub := range schema.AllOf {
+			if object, ok := o.exampleOf(sub, expanding).(map[string]interface{}); ok {
+				for name, value := range object {
+					merged[name] = value
+				}
+			}
+		}
+		return merged
+	case len(schema.OneOf) > 0:
+		return o.exampleOf(schema.OneOf[0], expanding)
+	case len(schema.AnyOf) > 0:
+		return o.exampleOf(schema.AnyOf[0], expanding)
+	}
+
+	switch {
+	case schema.Type.has("object") || len(schema.Properties) > 0:
+		object := make(map[string]interface{}, len(schema.Properties))
+		for name, property := range schema.Properties {
+			if resolved := o.resolveSchema(property); resolved != nil && resolved.WriteOnly {
+				continue
+			}
+			if value := o.exampleOf(property, expanding); value != nil {
+				object[name] = value
+			}
+		}
+		return object
+	case schema.Type.has("array"):
+		if item := o.exampleOf(schema.Items, expanding); item != nil {
+			return []interface{}{item}
+		}
+		return []interface{}{}
+	case schema.Type.has("string"):
+		return exampleString(schema)
+	case schema.Type.has("integer"), schema.Type.has("number"):
+		if schema.Minimum != nil {
+			return *schema.Minimum
+		}
+		return 0
+	case schema.Type.has("boolean"):
+		return false
+	}
+
+	return nil
+}
+
+func exampleString(schema *OpenAPISchema) string {
+	switch schema.Format {
+	case "date-time":
+		return "2006-01-02T15:04:05Z"
+	case "date":
+		return "2006-01-02"
+	case "uuid":
+		return "00000000-0000-0000-0000-000000000000"
+	case "email":
+		return "user@example.com"
+	case "uri", "url":
+		return "https://example.com"
+	}
+
+	value := "string"
+	if schema.MinLength != nil && len(value) < *schema.MinLength {
+		value += strings.Repeat("x", *schema.MinLength-len(value))
+	}
+	if schema.MaxLength != nil && len(value) > *schema.MaxLength {
+		value = value[:*schema.MaxLength]
+	}
+
+	return value
+}
+
+// synthesizeMiss adds a generated record with a response built from the OpenAPI document
+// and replays it.
+func (c *Cassette) synthesizeMiss(recorder httpOutcomeRecorder, rec *record, spec *OpenAPI, errBefore error) error {
+	req, err := httpReadRequest(rec.Request)
+	if err != nil {
+		return ErrPlaybackFailed
+	}
+
+	res, err := spec.synthesize(req)
+	if err != nil {
+		return ErrPlaybackFailed
+	}
+
+	rec.Response = httpDumpResponse(res)
+	rec.Generated = true
+
+	if c.Add(rec) == nil {
+		err = c.playbackRecorder(recorder)
+		if err != ErrPlaybackFailed {
+			c.setError(errBefore)
+			return err
+		}
+	}
+
+	c.setError(errBefore)
+	recorder.respond(res)
+	return nil
+}
+
+// GeneratedRecords returns the records synthesized from an OpenAPI document on playback misses.
+// They are replaced by real recordings in ModePlaybackOrRecord and ModePlaybackSuccessOrRecord.
+func (c *Cassette) GeneratedRecords() []RecordRef {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var refs []RecordRef
+	for _, rec := range sortRecords(c.records(), false) {
+		if rec.Generated {
+			refs = append(refs, newRecordRef(rec))
+		}
+	}
+
+	return refs
+}
+
+// replaceGenerated moves the contents of a recorded record into the place of the first
+// generated record of its track. The cassette file is rewritten on Finalize.
+func (c *Cassette) replaceGenerated(rec *record) bool {
+	if rec.Generated || c.tracks[rec.Kind] == nil || c.tracks[rec.Kind][rec.Key] == nil {
+		return false
+	}
+
+	for _, generated := range c.tracks[rec.Kind][rec.Key].records {
+		if !generated.Generated {
+			continue
+		}
+
+		id, replay, played := generated.ID, generated.Replay, generated.played
+		*generated = *rec
+		generated.ID, generated.Replay, generated.played = id, replay, played
+		rec.ID = id
+
+		c.dirty = true
+		c.notifyRecordAdded()
+
+		return true
+	}
+
+	return false
+}
+
+// skipsGenerated reports whether generated records are misses, so real calls are recorded instead.
+func (c *Cassette) skipsGenerated() bool {
+	return c.mode == ModePlaybackOrRecord || c.mode == ModePlaybackSuccessOrRecord
+}
@@ -0,0 +1,92 @@
+package playback
+
+import (
+	"fmt"
+)
+
+// RecordRef identifies a record of a cassette.
+type RecordRef struct {
+	ID   uint64
+	Kind RecordKind
+	Key  string
+}
+
+func newRecordRef(rec *record) RecordRef {
+	return RecordRef{
+		ID:   rec.ID,
+		Kind: rec.Kind,
+		Key:  rec.Key,
+	}
+}
+
+func (r RecordRef) String() string {
+	return fmt.Sprintf("#%d %s %q", r.ID, r.Kind, r.Key)
+}
+
+// OrderError is reported in strict order mode when an interaction is replayed
+// before an interaction that was recorded earlier.
+type OrderError struct {
+	Expected RecordRef
+	Got      RecordRef
+}
+
+func (e *OrderError) Error() string {
+	return fmt.Sprintf("Playback order violated: expected record %s, got record %s", e.Expected, e.Got)
+}
+
+func isIncomingKind(kind RecordKind) bool {
+	return kind == KindHTTPRequest || kind == KindGRPCRequest
+}
+
+// checkOrder verifies that rec is the earliest not yet replayed record of the cassette.
+// The first divergence is kept as the cassette error.
+func (c *Cassette) checkOrder(rec *record) error {
+	if isIncomingKind(rec.Kind) || !rec.isOrdered() {
+		return nil
+	}
+
+	expected := c.nextInOrder()
+	if expected == nil || expected == rec {
+		return nil
+	}
+
+	err := &OrderError{
+		Expected: newRecordRef(expected),
+		Got:      newRecordRef(rec),
+	}
+	if c.err == nil {
+		c.err = err
+	}
+
+	return err
+}
+
+func (c *Cassette) nextInOrder() *record {
+	var next *record
+	for kind, kindTracks := range c.tracks {
+		if isIncomingKind(kind) {
+			continue
+		}
+
+		for _, keyTrack := range kindTracks {
+			for _, rec := range keyTrack.records[keyTrack.cursor:] {
+				if rec.Replay.Mode == ReplayUnordered {
+					continue
+				}
+
+				if rec.played == 0 && (next == nil || rec.ID < next.ID) {
+					next = rec
+				}
+				break
+			}
+		}
+	}
+
+	return next
+}
+
+// isOrdered reports whether the record still has a fixed place in the global order.
+// Unordered records and repeated replays of sticky records don't.
+func (r *record) isOrdered() bool {
+	return r.Replay.Mode != ReplayUnordered && r.played == 0
+}
@@ -0,0 +1,334 @@
+package playback
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const pactSpecificationVersion = "3.0.0"
+
+var (
+	pactPathSegmentRegexp = regexp.MustCompile(`\.([^.\[]+)|\[(\*|[0-9]+)\]`)
+
+	// pactSkippedRequestHeaders are set by the Go HTTP client and aren't expectations of the consumer.
+	pactSkippedRequestHeaders = map[string]bool{
+		"Host":            true,
+		"User-Agent":      true,
+		"Accept-Encoding": true,
+		"Content-Length":  true,
+	}
+	pactSkippedResponseHeaders = map[string]bool{
+		"Content-Length": true,
+		"Date":           true,
+	}
+)
+
+// PactOptions configures the export of a cassette into Pact contracts.
+type PactOptions struct {
+	// Consumer is the name of the consumer, "playback" by default.
+	Consumer string
+	// Providers names the providers by host, the host is the name otherwise.
+	Providers map[string]string
+	// Volatile lists the JSON paths of body fields, like $.id or $.items[*].created_at,
+	// whose values are loosened into type matchers.
+	Volatile []string
+	// VolatileHeaders lists the headers whose values are loosened into type matchers.
+	VolatileHeaders []string
+}
+
+type Pact struct {
+	Consumer     PactParticipant   `json:"consumer"`
+	Provider     PactParticipant   `json:"provider"`
+	Interactions []PactInteraction `json:"interactions"`
+	Metadata     PactMetadata      `json:"metadata"`
+}
+
+type PactParticipant struct {
+	Name string `json:"name"`
+}
+
+type PactMetadata struct {
+	PactSpecification struct {
+		Version string `json:"version"`
+	} `json:"pactSpecification"`
+}
+
+type PactInteraction struct {
+	Description    string        `json:"description"`
+	ProviderStates []interface{} `json:"providerStates,omitempty"`
+	Request        PactRequest   `json:"request"`
+	Response       PactResponse  `json:"response"`
+}
+
+type PactRequest struct {
+	Method        string              `json:"method"`
+	Path          string              `json:"path"`
+	Query         map[string][]string `json:"query,omitempty"`
+	Headers       map[string]string   `json:"headers,omitempty"`
+	Body          interface{}         `json:"body,omitempty"`
+	MatchingRules PactMatchingRules   `json:"matchingRules,omitempty"`
+}
+
+type PactResponse struct {
+	Status        int               `json:"status"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	Body          interface{}       `json:"body,omitempty"`
+	MatchingRules PactMatchingRules `json:"matchingRules,omitempty"`
+}
+
+// PactMatchingRules are the matching rules of a request or a response by category: body and header.
+type PactMatchingRules map[string]map[string]PactMatchers
+
+type PactMatchers struct {
+	Matchers []PactMatcher `json:"matchers"`
+}
+
+type PactMatcher struct {
+	Match string `json:"match"`
+}
+
+// MarshalToPact exports the http records of the cassette into Pact v3 contracts, one per provider host.
+// The contracts are keyed by provider name.
+func (c *Cassette) MarshalToPact(options PactOptions) (map[string][]byte, error) {
+	pacts, err := c.Pacts(options)
+	if err != nil {
+		return nil, err
+	}
+
+	dumps := make(map[string][]byte, len(pacts))
+	for provider, pact := range pacts {
+		dump, err := json.MarshalIndent(pact, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		dumps[provider] = dump
+	}
+
+	return dumps, nil
+}
+
+// Pacts builds Pact v3 contracts of the http records of the cassette, one per provider host.
+func (c *Cassette) Pacts(options PactOptions) (map[string]*Pact, error) {
+	c.mu.RLock()
+	records := sortRecords(c.resolved(c.records()), false)
+	c.mu.RUnlock()
+
+	consumer := options.Consumer
+	if consumer == "" {
+		consumer = "playback"
+	}
+
+	pacts := make(map[string]*Pact)
+	descriptions := make(map[string]int)
+	for _, rec := range records {
+		if rec.Kind != KindHTTP || rec.Request == "" || rec.Response == "" {
+			continue
+		}
+
+		host, interaction, err := pactInteraction(rec, options)
+		if err != nil {
+			return nil, err
+		}
+
+		provider := options.Providers[host]
+		if provider == "" {
+			provider = host
+		}
+
+		pact := pacts[provider]
+		if pact == nil {
+			pact = &Pact{
+				Consumer: PactParticipant{Name: consumer},
+				Provider: PactParticipant{Name: provider},
+			}
+			pact.Metadata.PactSpecification.Version = pactSpecificationVersion
+			pacts[provider] = pact
+		}
+
+		// Interaction descriptions must be unique within a pact
+		description := provider + " " + interaction.Description
+		descriptions[description]++
+		if n := descriptions[description]; n > 1 {
+			interaction.Description += " #" + strconv.Itoa(n)
+		}
+
+		pact.Interactions = append(pact.Interactions, interaction)
+	}
+
+	return pacts, nil
+}
+
+func pactInteraction(rec *record, options PactOptions) (string, PactInteraction, error) {
+	req, err := httpReadRequest(rec.Request)
+	if err != nil {
+		return "", PactInteraction{}, err
+	}
+
+	requestBody, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return "", PactInteraction{}, err
+	}
+
+	res, err := httpReadResponse(rec.Response, req)
+	if err != nil {
+		return "", PactInteraction{}, err
+	}
+	defer res.Body.Close()
+
+	responseBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", PactInteraction{}, err
+	}
+
+	interaction := PactInteraction{
+		Description: req.Method + " " + req.URL.RequestURI(),
+		Request: PactRequest{
+			Method:  req.Method,
+			Path:    req.URL.Path,
+			Query:   pactQuery(req.URL.Query()),
+			Headers: pactHeaders(req.Header, pactSkippedRequestHeaders),
+			Body:    pactBody(requestBody, req.Header.Get("Content-Type")),
+		},
+		Response: PactResponse{
+			Status:  res.StatusCode,
+			Headers: pactHeaders(res.Header, pactSkippedResponseHeaders),
+			Body:    pactBody(responseBody, res.Header.Get("Content-Type")),
+		},
+	}
+	interaction.Request.MatchingRules = pactMatchingRules(interaction.Request.Body, interaction.Request.Headers, options)
+	interaction.Response.MatchingRules = pactMatchingRules(interaction.Response.Body, interaction.Response.Headers, options)
+
+	return req.Host, interaction, nil
+}
+
+func pactQuery(query url.Values) map[string][]string {
+	if len(query) == 0 {
+		return nil
+	}
+
+	return query
+}
+
+func pactHeaders(header http.Header, skipped map[string]bool) map[string]string {
+	headers := make(map[string]string, len(header))
+	for name, values := range header {
+		if skipped[name] {
+			continue
+		}
+		headers[name] = strings.Join(values, ", ")
+	}
+	if len(headers) == 0 {
+		return nil
+	}
+
+	return headers
+}
+
+func pactBody(body []byte, contentType string) interface{} {
+	if len(body) == 0 {
+		return nil
+	}
+
+	if strings.Contains(contentType, "json") || contentType == "" {
+		var value interface{}
+		if json.Unmarshal(body, &value) == nil {
+			return value
+		}
+	}
+
+	return string(body)
+}
+
+func pactMatchingRules(body interface{}, headers map[string]string, options PactOptions) PactMatchingRules {
+	rules := make(PactMatchingRules)
+	typeMatchers := PactMatchers{Matchers: []PactMatcher{{Match: "type"}}}
+
+	for _, path := range options.Volatile {
+		if !jsonPathExists(body, path) {
+			continue
+		}
+		if rules["body"] == nil {
+			rules["body"] = make(map[string]PactMatchers)
+		}
+		rules["body"][path] = typeMatchers
+	}
+
+	for _, name := range options.VolatileHeaders {
+		name = http.CanonicalHeaderKey(name)
+		if _, ok := headers[name]; !ok {
+			continue
+		}
+		if rules["header"] == nil {
+			rules["header"] = make(map[string]PactMatchers)
+		}
+		rules["header"][name] = typeMatchers
+	}
+
+	if len(rules) == 0 {
+		return nil
+	}
+
+	return rules
+}
+
+// jsonPathExists reports whether a JSON path of the $.field, $[0] and $.list[*].field forms
+// selects a value of the document.
+func jsonPathExists(document interface{}, path string) bool {
+	if document == nil || !strings.HasPrefix(path, "$") {
+		return false
+	}
+
+	rest := path[1:]
+	segments := pactPathSegmentRegexp.FindAllStringSubmatch(rest, -1)
+	if len(strings.Join(flattenMatches(segments), "")) != len(rest) {
+		return false
+	}
+
+	values := []interface{}{document}
+	for _, segment := range segments {
+		var next []interface{}
+		for _, value := range values {
+			switch {
+			case segment[1] != "":
+				if object, ok := value.(map[string]interface{}); ok {
+					if field, ok := object[segment[1]]; ok {
+						next = append(next, field)
+					}
+				}
+			case segment[2] == "*":
+				if list, ok := value.([]interface{}); ok {
+					next = append(next, list...)
+				}
+			default:
+				index, _ := strconv.Atoi(segment[2])
+				if list, ok := value.([]interface{}); ok && index < len(list) {
+					next = append(next, list[index])
+				}
+			}
+		}
+		values = next
+	}
+
+	return len(values) > 0
+}
+
+func flattenMatches(matches [][]string) []string {
+	flat := make([]string, 0, len(matches))
+	for _, match := range matches {
+		flat = append(flat, match[0])
+	}
+
+	return flat
+}
+
+// PactFilename returns the conventional name of a pact file.
+func PactFilename(consumer, provider string) string {
+	name := strings.NewReplacer("/", "_", ":", "_", " ", "_").Replace(consumer + "-" + provider)
+	return fmt.Sprintf("%s.json", name)
+}
@@ -1,6 +1,7 @@
 package playback
 
 import (
+	"io/fs"
 	"net/http"
 	"sync"
 	"time"
@@ -11,13 +12,31 @@ var defaultCassetteTTL = 3 * time.Hour
 type Playback struct {
 	Error error
 
-	defaultMode Mode
-	cassetteTTL time.Duration
-	debug       bool
-	logger      Logger
-	fileMask    string
-	withFile    bool
-	cassettes   map[string]*Cassette
+	defaultMode   Mode
+	cassetteTTL   time.Duration
+	debug         bool
+	strictOrder   bool
+	latency       Latency
+	maxAge        time.Duration
+	approval      bool
+	canonical     bool
+	format        Format
+	compressed    bool
+	layout        Layout
+	openAPI       *OpenAPI
+	faults        Faults
+	logger        Logger
+	fileMask      string
+	withFile      bool
+	noHeaderFiles bool
+	cassetteRoot  string
+	cassettes     map[string]*Cassette
+	stores        map[PathType]Store
+	library       *library
+	authorizer    Authorizer
+	events        *eventBus
+
+	missPolicies map[RecordKind]MissPolicy
 
 	mu sync.RWMutex
 }
@@ -27,9 +46,14 @@ type Option func(*Playback)
 func New() *Playback {
 	p := &Playback{
 		fileMask:    FileMask,
+		format:      FormatYAML,
 		cassettes:   make(map[string]*Cassette),
+		stores:      make(map[PathType]Store),
+		events:      newEventBus(),
 		logger:      &defaultLogger{},
 		cassetteTTL: defaultCassetteTTL,
+
+		missPolicies: make(map[RecordKind]MissPolicy),
 	}
 
 	return p
@@ -53,10 +77,37 @@ func (p *Playback) CassetteFromFile(filename string) (*Cassette, error) {
 	return newCassetteFromFile(p, filename)
 }
 
+// CassetteFromFS loads a cassette file or directory from the file system, like an embed.FS.
+// The cassette is read-only.
+func (p *Playback) CassetteFromFS(fsys fs.FS, name string) (*Cassette, error) {
+	return newCassetteFromFS(p, fsys, name)
+}
+
+// CassetteFromStore loads the cassette saved under the name in the store registered for the path type.
+func (p *Playback) CassetteFromStore(pathType PathType, name string) (*Cassette, error) {
+	return newCassetteFromStore(p, pathType, name)
+}
+
+// CassetteFromYAML loads a cassette from a dump.
+// The format is detected, so JSON and JSON Lines dumps are accepted too.
 func (p *Playback) CassetteFromYAML(yamlBody []byte) (*Cassette, error) {
 	return newCassetteFromYAML(p, yamlBody)
 }
 
+// CassetteFromHAR converts the entries of a HAR dump into a cassette, see Cassette.AddFromHAR.
+func (p *Playback) CassetteFromHAR(har []byte, kind RecordKind) (*Cassette, error) {
+	c := newCassette(p)
+	c.SetMode(ModePlayback)
+
+	err := c.AddFromHAR(har, kind)
+	if err != nil {
+		p.Delete(c.ID)
+		return nil, err
+	}
+
+	return c, nil
+}
+
 func (p *Playback) WithFile() *Playback {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -113,6 +164,178 @@ func (p *Playback) Debug() bool {
 	return p.debug
 }
 
+func (p *Playback) SetStrictOrder(strict bool) *Playback {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.strictOrder = strict
+
+	return p
+}
+
+func (p *Playback) StrictOrder() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.strictOrder
+}
+
+func (p *Playback) SetMissPolicy(kind RecordKind, policy MissPolicy) *Playback {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.missPolicies[kind] = policy
+
+	return p
+}
+
+func (p *Playback) MissPolicies() map[RecordKind]MissPolicy {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	policies := make(map[RecordKind]MissPolicy, len(p.missPolicies))
+	for kind, policy := range p.missPolicies {
+		policies[kind] = policy
+	}
+
+	return policies
+}
+
+func (p *Playback) SetLatency(latency Latency) *Playback {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.latency = latency
+
+	return p
+}
+
+func (p *Playback) Latency() Latency {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.latency
+}
+
+func (p *Playback) SetFaults(faults Faults) *Playback {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.faults = faults
+
+	return p
+}
+
+func (p *Playback) Faults() Faults {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.faults
+}
+
+func (p *Playback) SetMaxAge(maxAge time.Duration) *Playback {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.maxAge = maxAge
+
+	return p
+}
+
+func (p *Playback) MaxAge() time.Duration {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.maxAge
+}
+
+func (p *Playback) SetApproval(approval bool) *Playback {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.approval = approval
+
+	return p
+}
+
+func (p *Playback) Approval() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.approval
+}
+
+func (p *Playback) SetCanonical(canonical bool) *Playback {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.canonical = canonical
+
+	return p
+}
+
+func (p *Playback) Canonical() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.canonical
+}
+
+func (p *Playback) SetFormat(format Format) *Playback {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.format = format
+
+	return p
+}
+
+func (p *Playback) Format() Format {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.format
+}
+
+// SetStore registers the store for the path type, so x-playback-path-type headers can select it.
+func (p *Playback) SetStore(pathType PathType, store Store) *Playback {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if store == nil {
+		delete(p.stores, pathType)
+	} else {
+		p.stores[pathType] = store
+	}
+
+	return p
+}
+
+func (p *Playback) Store(pathType PathType) Store {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.stores[pathType]
+}
+
+// SetOpenAPI makes new cassettes check the http records they write against the OpenAPI document,
+// see Cassette.SetOpenAPI.
+func (p *Playback) SetOpenAPI(spec *OpenAPI) *Playback {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.openAPI = spec
+
+	return p
+}
+
+func (p *Playback) OpenAPI() *OpenAPI {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.openAPI
+}
+
 func (p *Playback) SetLogger(logger Logger) *Playback {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -2,7 +2,6 @@ package playback
 
 import (
 	"errors"
-	"os"
 	"time"
 
 	yaml "gopkg.in/yaml.v2"
@@ -27,25 +26,28 @@ const (
 var ErrPlaybackFailed = errors.New("Playback failed")
 
 type record struct {
-	// TODO Obsolete - check
-	debounce time.Duration
-	basename string // TODO REMOVEME
-	file     *os.File
-	request  string
-	response string
-
-	// TODO New fields
-	Kind         RecordKind
-	Key          string
-	ID           uint64
-	RequestMeta  string
-	Request      string
-	ResponseMeta string
-	Response     string
-	Err          RecordError
-	Panic        interface{}
+	Kind         RecordKind    `json:"kind"`
+	Key          string        `json:"key"`
+	ID           uint64        `json:"id"`
+	RequestMeta  string        `json:"requestmeta"`
+	Request      string        `json:"request"`
+	RequestHTTP  *httpMessage  `yaml:"request_http,omitempty" json:"request_http,omitempty"`
+	ResponseMeta string        `json:"responsemeta"`
+	Response     string        `json:"response"`
+	ResponseHTTP *httpMessage  `yaml:"response_http,omitempty" json:"response_http,omitempty"`
+	ResponseBody string        `yaml:"response_body,omitempty" json:"response_body,omitempty"`
+	Err          RecordError   `json:"err"`
+	Panic        interface{}   `json:"panic"`
+	Match        *HTTPMatch    `yaml:"match,omitempty" json:"match,omitempty"`
+	Replay       ReplayPolicy  `yaml:"replay,omitempty" json:"replay,omitzero"`
+	Duration     time.Duration `yaml:"duration,omitempty" json:"duration,omitempty"`
+	CreatedAt    time.Time     `yaml:"created_at,omitempty" json:"created_at,omitzero"`
+	Pending      bool          `yaml:"pending,omitempty" json:"pending,omitempty"`
+	Generated    bool          `yaml:"generated,omitempty" json:"generated,omitempty"`
 
 	cassette *Cassette
+	matched  *record
+	played   int
 }
 
 func (r *record) Record() {
@@ -86,7 +88,7 @@ func (r *record) Playback() error {
 }
 
 func (r *record) playback() error {
-	record, err := r.cassette.Get(r.Kind, r.Key)
+	record, err := r.cassette.match(r)
 	if err != nil {
 		return err
 	}
@@ -96,6 +98,8 @@ func (r *record) playback() error {
 	r.Response = record.Response
 	r.Err = record.Err
 	r.Panic = record.Panic
+	r.Duration = record.Duration
+	r.matched = record
 
 	return nil
 }
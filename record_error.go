@@ -2,7 +2,8 @@ package playback
 
 import (
 	"context"
-	"fmt"
+	"encoding/json"
+	"errors"
 )
 
 const errTypeContextDeadlineExceeded = "context.DeadlineExceeded"
@@ -27,6 +28,28 @@ func (e RecordError) MarshalYAML() (interface{}, error) {
 	return e.Error(), nil
 }
 
+func (e RecordError) MarshalJSON() ([]byte, error) {
+	value, _ := e.MarshalYAML()
+	return json.Marshal(value)
+}
+
+func (e *RecordError) UnmarshalJSON(data []byte) error {
+	var errString *string
+	if err := json.Unmarshal(data, &errString); err != nil {
+		return err
+	}
+
+	if errString == nil {
+		e.error = nil
+		return nil
+	}
+
+	return e.UnmarshalYAML(func(value interface{}) error {
+		*(value.(*string)) = *errString
+		return nil
+	})
+}
+
 func (e *RecordError) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	var errString string
 	if err := unmarshal(&errString); err != nil {
@@ -38,6 +61,6 @@ func (e *RecordError) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		return nil
 	}
 
-	e.error = fmt.Errorf(errString)
+	e.error = errors.New(errString)
 	return nil
 }
@@ -0,0 +1,129 @@
+package playback
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func (c *Cassette) MaxAge() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.maxAge
+}
+
+// SetMaxAge sets the age after which records are re-recorded in ModeRefreshStale.
+// Records without creation time are considered stale.
+func (c *Cassette) SetMaxAge(maxAge time.Duration) *Cassette {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxAge = maxAge
+
+	return c
+}
+
+func (c *Cassette) isStale(rec *record) bool {
+	maxAge := c.MaxAge()
+	if maxAge <= 0 {
+		return false
+	}
+
+	return rec.CreatedAt.IsZero() || time.Since(rec.CreatedAt) > maxAge
+}
+
+// refreshStale replays fresh records and re-records stale and missing ones.
+func (c *Cassette) refreshStale(recorder Recorder) error {
+	err := recorder.Playback()
+	if err == ErrPlaybackFailed {
+		c.emitMiss(recorder)
+		return recorder.Record()
+	}
+
+	outcome, ok := recorder.(outcomeRecorder)
+	if !ok || outcome.lookup() == nil || outcome.lookup().matched == nil {
+		return err
+	}
+
+	stale := outcome.lookup().matched
+	if !c.isStale(stale) {
+		return err
+	}
+
+	err = recorder.Record()
+	c.refresh(stale, outcome.lookup())
+
+	return err
+}
+
+// refresh moves the contents of the fresh record into the place of the stale one.
+func (c *Cassette) refresh(stale, fresh *record) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if stale == fresh || c.recordByID[fresh.ID] != fresh {
+		return
+	}
+
+	track := c.tracks[fresh.Kind][fresh.Key]
+	for i, rec := range track.records {
+		if rec == fresh {
+			track.records = append(track.records[:i], track.records[i+1:]...)
+			break
+		}
+	}
+	delete(c.recordByID, fresh.ID)
+
+	id, replay, played := stale.ID, stale.Replay, stale.played
+	*stale = *fresh
+	stale.ID, stale.Replay, stale.played = id, replay, played
+
+	c.dirty = true
+}
+
+// rewrite replaces the cassette file with the current records.
+func (c *Cassette) rewrite() error {
+	if w, ok := c.writer.(*storeWriter); ok {
+		dump, err := c.marshal()
+		if err != nil {
+			return err
+		}
+
+		c.dirty = false
+
+		return w.replace(dump)
+	}
+
+	filename := c.filename()
+	if filename == "" {
+		return nil
+	}
+
+	dump, err := c.marshal()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(compressFor(filename, dump))
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	c.dirty = false
+
+	return os.Rename(tmp.Name(), filename)
+}
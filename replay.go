@@ -0,0 +1,128 @@
+package playback
+
+import "errors"
+
+// ReplayMode defines how many times and in which order a record can be replayed.
+type ReplayMode string
+
+const (
+	// ReplayOnce records are consumed by exactly one call, in the recorded order.
+	ReplayOnce ReplayMode = ""
+	// ReplaySticky records are replayed forever and never release the track cursor,
+	// so the records after a sticky record in its track are never replayed.
+	ReplaySticky ReplayMode = "sticky"
+	// ReplayRepeat records are replayed up to ReplayPolicy.Times times.
+	ReplayRepeat ReplayMode = "repeat"
+	// ReplayUnordered records are consumed once, but may be matched by request
+	// out of order within a track. Strict order mode ignores them.
+	// Only sql_result records support it: the other kinds are keyed by the request or have none.
+	ReplayUnordered ReplayMode = "unordered"
+)
+
+// ErrReplayUnsupported is returned when a replay policy can't apply to the kind of records.
+var ErrReplayUnsupported = errors.New("Replay policy isn't supported for the record kind")
+
+type ReplayPolicy struct {
+	Mode  ReplayMode `yaml:"mode,omitempty" json:"mode,omitempty"`
+	Times int        `yaml:"times,omitempty" json:"times,omitempty"`
+}
+
+func (p ReplayPolicy) exhausted(played int) bool {
+	switch p.Mode {
+	case ReplaySticky:
+		return false
+	case ReplayRepeat:
+		return played >= p.Times && played > 0
+	}
+
+	return played > 0
+}
+
+// next picks the record to replay for the request.
+// It returns nil when the record at the cursor is unordered and no unordered record matches the request.
+func (t *track) next(request string) *record {
+	rec := t.records[t.cursor]
+	if request == "" || rec.Request == request {
+		return rec
+	}
+
+	for _, candidate := range t.records[t.cursor+1:] {
+		if candidate.Replay.Mode == ReplayUnordered && candidate.played == 0 && candidate.Request == request {
+			return candidate
+		}
+	}
+
+	if rec.Replay.Mode == ReplayUnordered {
+		return nil
+	}
+
+	return rec
+}
+
+func (t *track) play(rec *record) {
+	rec.played++
+
+	for t.cursor < len(t.records) && t.records[t.cursor].Replay.exhausted(t.records[t.cursor].played) {
+		t.cursor++
+	}
+}
+
+func (t *track) rewind() {
+	t.cursor = 0
+
+	for _, rec := range t.records {
+		rec.played = 0
+	}
+}
+
+// succeeded reports whether every record of the track was replayed at least once.
+func (t *track) succeeded() bool {
+	for _, rec := range t.records {
+		if rec.played == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func supportsReplay(kind RecordKind, policy ReplayPolicy) bool {
+	return policy.Mode != ReplayUnordered || kind == KindSQLResult
+}
+
+// SetReplayPolicy sets the policy of every record of the track.
+func (c *Cassette) SetReplayPolicy(kind RecordKind, key string, policy ReplayPolicy) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.tracks[kind] == nil || c.tracks[kind][key] == nil {
+		return errCassetteGetFailed
+	}
+	if !supportsReplay(kind, policy) {
+		return ErrReplayUnsupported
+	}
+
+	for _, rec := range c.tracks[kind][key].records {
+		rec.Replay = policy
+	}
+
+	return nil
+}
+
+// SetRecordReplayPolicy sets the policy of the record with the ID.
+func (c *Cassette) SetRecordReplayPolicy(id uint64, policy ReplayPolicy) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	track, i := c.findRecord(id)
+	if track == nil {
+		return errRecordNotFound
+	}
+	if !supportsReplay(track.records[i].Kind, policy) {
+		return ErrReplayUnsupported
+	}
+
+	track.records[i].Replay = policy
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package playback
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrackReplayPolicy(t *testing.T) {
+	t.Run("unordered records are matched by request", func(t *testing.T) {
+		unordered := ReplayPolicy{Mode: ReplayUnordered}
+		track := &track{records: []*record{
+			{ID: 1, Request: "a", Replay: unordered},
+			{ID: 2, Request: "b", Replay: unordered},
+			{ID: 3, Request: "c"},
+		}}
+
+		rec := track.next("b")
+		assert.Equal(t, uint64(2), rec.ID)
+		track.play(rec)
+		assert.Equal(t, 0, track.cursor)
+
+		rec = track.next("a")
+		assert.Equal(t, uint64(1), rec.ID)
+		track.play(rec)
+		assert.Equal(t, 2, track.cursor)
+
+		rec = track.next("c")
+		assert.Equal(t, uint64(3), rec.ID)
+		track.play(rec)
+		assert.True(t, track.succeeded())
+	})
+	t.Run("unordered records miss an unknown request", func(t *testing.T) {
+		unordered := ReplayPolicy{Mode: ReplayUnordered}
+		track := &track{records: []*record{
+			{ID: 1, Request: "a", Replay: unordered},
+			{ID: 2, Request: "b", Replay: unordered},
+		}}
+
+		assert.Nil(t, track.next("c"))
+		assert.Equal(t, uint64(1), track.next("").ID)
+	})
+	t.Run("unordered records are matched before an ordered record", func(t *testing.T) {
+		track := &track{records: []*record{
+			{ID: 1, Request: "a"},
+			{ID: 2, Request: "b", Replay: ReplayPolicy{Mode: ReplayUnordered}},
+		}}
+
+		rec := track.next("b")
+		assert.Equal(t, uint64(2), rec.ID)
+		track.play(rec)
+		assert.Equal(t, uint64(1), track.next("c").ID)
+	})
+}
@@ -0,0 +1,188 @@
+package playback
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// HeaderReport is the response header with the path of the playback report of the cassette.
+const HeaderReport = "x-playback-report"
+
+// ReportPath is the path of the playback report endpoint of the service.
+const ReportPath = "/playback/report/"
+
+// Report describes how a cassette was replayed since it was loaded or rewound.
+type Report struct {
+	CassetteID string                     `json:"cassette_id" yaml:"cassette_id"`
+	Mode       Mode                       `json:"mode" yaml:"mode"`
+	Succeeded  bool                       `json:"succeeded" yaml:"succeeded"`
+	Error      string                     `json:"error,omitempty" yaml:"error,omitempty"`
+	Kinds      map[RecordKind]*KindReport `json:"kinds" yaml:"kinds"`
+}
+
+// KindReport lists the records of a kind by their outcome.
+// Incoming requests aren't replayed, so only their response mismatches are reported.
+type KindReport struct {
+	Matched    []ReportRecord   `json:"matched,omitempty" yaml:"matched,omitempty"`
+	Missed     []ReportMiss     `json:"missed,omitempty" yaml:"missed,omitempty"`
+	Unconsumed []ReportRecord   `json:"unconsumed,omitempty" yaml:"unconsumed,omitempty"`
+	Mismatched []ReportMismatch `json:"mismatched,omitempty" yaml:"mismatched,omitempty"`
+}
+
+// ReportRecord is a record of the cassette and how many times it was replayed.
+type ReportRecord struct {
+	ID     uint64 `json:"id" yaml:"id"`
+	Key    string `json:"key" yaml:"key"`
+	Played int    `json:"played" yaml:"played"`
+}
+
+// ReportMiss is a call no record matched, with the diff from the nearest record of the kind.
+type ReportMiss struct {
+	Key     string        `json:"key" yaml:"key"`
+	Request string        `json:"request" yaml:"request"`
+	Nearest *ReportRecord `json:"nearest,omitempty" yaml:"nearest,omitempty"`
+	Diff    string        `json:"diff,omitempty" yaml:"diff,omitempty"`
+}
+
+// ReportMismatch is a response of the service that differs from the recorded one.
+type ReportMismatch struct {
+	Diff string `json:"diff" yaml:"diff"`
+}
+
+// Report returns the matched, missed and unconsumed records of each kind.
+func (c *Cassette) Report() *Report {
+	succeeded := c.IsPlaybackSucceeded()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	report := &Report{
+		CassetteID: c.ID,
+		Mode:       c.mode,
+		Succeeded:  succeeded,
+		Kinds:      make(map[RecordKind]*KindReport),
+	}
+	if c.err != nil {
+		report.Error = c.err.Error()
+	}
+
+	kindReport := func(kind RecordKind) *KindReport {
+		if report.Kinds[kind] == nil {
+			report.Kinds[kind] = &KindReport{}
+		}
+		return report.Kinds[kind]
+	}
+
+	for _, rec := range sortRecords(c.records(), false) {
+		if isIncomingKind(rec.Kind) {
+			continue
+		}
+
+		ref := ReportRecord{ID: rec.ID, Key: rec.Key, Played: rec.played}
+		if rec.played > 0 {
+			kindReport(rec.Kind).Matched = append(kindReport(rec.Kind).Matched, ref)
+		} else {
+			kindReport(rec.Kind).Unconsumed = append(kindReport(rec.Kind).Unconsumed, ref)
+		}
+	}
+
+	for _, miss := range c.misses {
+		reportMiss := ReportMiss{Key: miss.Key, Request: miss.Request}
+		if nearest := c.nearestRecord(miss); nearest != nil {
+			reportMiss.Nearest = &ReportRecord{ID: nearest.ID, Key: nearest.Key, Played: nearest.played}
+			reportMiss.Diff = lineDiff(nearest.Request, miss.Request)
+		}
+		kindReport(miss.Kind).Missed = append(kindReport(miss.Kind).Missed, reportMiss)
+	}
+
+	for _, mismatch := range c.mismatches {
+		kindReport(mismatch.kind).Mismatched = append(kindReport(mismatch.kind).Mismatched, mismatch.ReportMismatch)
+	}
+
+	return report
+}
+
+// responseMismatch is a ReportMismatch of a kind.
+type responseMismatch struct {
+	ReportMismatch
+	kind RecordKind
+}
+
+// addMiss keeps the call no record matched for the report.
+func (c *Cassette) addMiss(rec *record) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.misses = append(c.misses, &record{Kind: rec.Kind, Key: rec.Key, Request: rec.Request})
+}
+
+// addMismatch keeps the difference of the recorded and the actual response for the report.
+func (c *Cassette) addMismatch(kind RecordKind, expected, actual string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.mismatches = append(c.mismatches, responseMismatch{
+		ReportMismatch: ReportMismatch{Diff: lineDiff(expected, actual)},
+		kind:           kind,
+	})
+}
+
+// nearestRecord finds the record of the track of the miss, or of its kind, with the least different request.
+func (c *Cassette) nearestRecord(miss *record) *record {
+	var candidates []*record
+	if track := c.tracks[miss.Kind][miss.Key]; track != nil {
+		candidates = track.records
+	} else {
+		for _, track := range c.tracks[miss.Kind] {
+			candidates = append(candidates, track.records...)
+		}
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].ID < candidates[j].ID })
+	}
+
+	dmp := diffmatchpatch.New()
+	var nearest *record
+	distance := -1
+	for _, candidate := range candidates {
+		d := dmp.DiffLevenshtein(dmp.DiffMain(candidate.Request, miss.Request, false))
+		if distance < 0 || d < distance {
+			nearest, distance = candidate, d
+		}
+	}
+
+	return nearest
+}
+
+// lineDiff shows the lines removed from the expected text with "-" and added in the actual one with "+".
+func lineDiff(expected, actual string) string {
+	dmp := diffmatchpatch.New()
+	a, b, lines := dmp.DiffLinesToChars(expected, actual)
+	diffs := dmp.DiffCharsToLines(dmp.DiffMain(a, b, false), lines)
+
+	var diff strings.Builder
+	for _, d := range diffs {
+		prefix := "  "
+		switch d.Type {
+		case diffmatchpatch.DiffDelete:
+			prefix = "- "
+		case diffmatchpatch.DiffInsert:
+			prefix = "+ "
+		}
+
+		for _, line := range strings.SplitAfter(d.Text, "\n") {
+			if line == "" {
+				continue
+			}
+			diff.WriteString(prefix + strings.TrimSuffix(line, "\n") + "\n")
+		}
+	}
+
+	return diff.String()
+}
+
+// reportPath returns the path of the report of the cassette on the service.
+func reportPath(cassetteID string) string {
+	return ReportPath + "?id=" + url.QueryEscape(cassetteID)
+}
@@ -1,19 +1,24 @@
 package playback
 
 import (
+	"context"
 	"reflect"
+	"time"
 
 	yaml "gopkg.in/yaml.v2"
 )
 
 type resultRecorder struct {
 	cassette  *Cassette
+	rec       *record
 	key       string
 	typ       reflect.Type
 	typString string
+	input     interface{}
 	value     interface{}
 	panic     interface{}
 	err       error
+	duration  time.Duration
 }
 
 type resultResponse struct {
@@ -25,6 +30,7 @@ func newResultRecorder(cassette *Cassette, key string, value interface{}, panicO
 	r := &resultRecorder{
 		cassette: cassette,
 		key:      key,
+		input:    value,
 		value:    value,
 	}
 
@@ -50,6 +56,7 @@ func (r *resultRecorder) Record() error {
 func (r *resultRecorder) record() record {
 	r.applyIfFunc()
 	rec := r.newRecord()
+	rec.Duration = r.duration
 
 	rec.ResponseMeta = r.typ.String()
 	if r.typString != "" {
@@ -59,6 +66,7 @@ func (r *resultRecorder) record() record {
 	rec.Panic = r.panic
 	rec.Err = RecordError{r.err}
 
+	r.rec = &rec
 	rec.Record()
 
 	return rec
@@ -72,6 +80,7 @@ func (r *resultRecorder) Playback() (err error) {
 	}()
 
 	rec := r.newRecord()
+	r.rec = &rec
 
 	err = rec.Playback()
 	if err != nil {
@@ -82,9 +91,15 @@ func (r *resultRecorder) Playback() (err error) {
 		return ErrPlaybackFailed
 	}
 
+	if rec.ResponseMeta != r.typ.String() {
+		r.cassette.addMismatch(KindResult, rec.ResponseMeta, r.typ.String())
+		return ErrPlaybackFailed
+	}
+
 	value := reflect.New(r.typ).Interface()
 	err = yaml.Unmarshal([]byte(rec.Response), value)
-	if err != nil || rec.ResponseMeta != r.typ.String() {
+	if err != nil {
+		r.cassette.addMismatch(KindResult, rec.Response, "")
 		return ErrPlaybackFailed
 	}
 
@@ -95,6 +110,19 @@ func (r *resultRecorder) Playback() (err error) {
 	return nil
 }
 
+func (r *resultRecorder) lookup() *record {
+	return r.rec
+}
+
+func (r *resultRecorder) callContext() context.Context {
+	return context.Background()
+}
+
+func (r *resultRecorder) fail(err error) {
+	r.value = reflect.Zero(r.typ).Interface()
+	r.err = err
+}
+
 func (r *resultRecorder) newRecord() record {
 	return record{
 		Kind:     KindResult,
@@ -116,14 +144,17 @@ func (r *resultRecorder) fillInTyp() {
 	if typ.NumIn() > 0 || typ.NumOut() < 1 || typ.NumOut() > 2 || (typ.NumOut() == 2 && !typ.Out(1).Implements(errorInterface)) {
 		// TODO return error
 		panic("Incorrect type: " + typ.String())
-		return
 	}
 
 	r.typ = typ.Out(0)
 }
 
+// applyIfFunc computes the value from the original input,
+// so a failed playback doesn't affect a following call or record.
 func (r *resultRecorder) applyIfFunc() {
-	val := reflect.ValueOf(r.value)
+	r.value, r.err, r.panic = r.input, nil, nil
+
+	val := reflect.ValueOf(r.input)
 	if val.Kind() != reflect.Func {
 		return
 	}
@@ -135,6 +166,11 @@ func (r *resultRecorder) applyIfFunc() {
 		}
 	}()
 
+	start := time.Now()
+	defer func() {
+		r.duration = time.Since(start)
+	}()
+
 	results := val.Call([]reflect.Value{})
 	r.value = results[0].Interface()
 	if len(results) == 2 && !results[1].IsNil() {
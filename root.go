@@ -0,0 +1,112 @@
+package playback
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrOutsideCassetteRoot is returned for cassette files outside the cassette root.
+var ErrOutsideCassetteRoot = errors.New("Cassette path is outside the cassette root")
+
+// SetCassetteRoot confines cassette files to the directory.
+// Relative paths are resolved against the root, paths leading out of it, also through symlinks, are rejected.
+// New cassette files are created in the root.
+// Stores and the library aren't confined by it: a DirStore is confined to its own root.
+func (p *Playback) SetCassetteRoot(root string) *Playback {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.cassetteRoot = root
+	if root != "" {
+		if abs, err := filepath.Abs(root); err == nil {
+			p.cassetteRoot = abs
+		}
+	}
+
+	return p
+}
+
+func (p *Playback) CassetteRoot() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.cassetteRoot
+}
+
+// SetHeaderFileAccess allows or forbids the middleware to read and create cassette files
+// requested by the x-playback-path-type: file header. It is allowed by default.
+func (p *Playback) SetHeaderFileAccess(allowed bool) *Playback {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.noHeaderFiles = !allowed
+
+	return p
+}
+
+func (p *Playback) HeaderFileAccess() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return !p.noHeaderFiles
+}
+
+// cassettePath resolves the cassette file name against the cassette root and
+// checks that the file, once symlinks are followed, stays inside it.
+func (p *Playback) cassettePath(name string) (string, error) {
+	root := p.CassetteRoot()
+	if root == "" {
+		return name, nil
+	}
+
+	path := filepath.Clean(name)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+
+	// The checked path is returned, so opening it doesn't follow symlinks again
+	return confinedPath(root, path)
+}
+
+// confinedPath follows the symlinks of the path and checks that it stays inside the root.
+func confinedPath(root, path string) (string, error) {
+	realRoot, err := evalExistingSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+
+	realPath, err := evalExistingSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(realRoot, realPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", ErrOutsideCassetteRoot
+	}
+
+	return realPath, nil
+}
+
+// evalExistingSymlinks follows the symlinks of the longest existing prefix of the path.
+func evalExistingSymlinks(path string) (string, error) {
+	realPath, err := filepath.EvalSymlinks(path)
+	if !os.IsNotExist(err) {
+		return realPath, err
+	}
+
+	dir, base := filepath.Split(path)
+	dir = filepath.Clean(dir)
+	if dir == path {
+		return path, nil
+	}
+
+	realDir, err := evalExistingSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(realDir, base), nil
+}
@@ -0,0 +1,546 @@
+package playback
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// APIPrefix is the path of the versioned JSON API of the playback service.
+const APIPrefix = "/playback/v1/"
+
+var errRecordNotFound = errors.New("Record not found")
+
+// apiError is the body of every error answered by the API.
+type apiError struct {
+	Status  int    `json:"status" yaml:"status"`
+	Code    string `json:"code" yaml:"code"`
+	Message string `json:"message" yaml:"message"`
+}
+
+type apiErrorBody struct {
+	Error apiError `json:"error" yaml:"error"`
+}
+
+// apiCassetteStatus describes the state of a cassette.
+type apiCassetteStatus struct {
+	ID        string `json:"id" yaml:"id"`
+	Name      string `json:"name,omitempty" yaml:"name,omitempty"`
+	Mode      Mode   `json:"mode" yaml:"mode"`
+	Locked    bool   `json:"locked" yaml:"locked"`
+	Succeeded bool   `json:"succeeded" yaml:"succeeded"`
+	Error     string `json:"error,omitempty" yaml:"error,omitempty"`
+	Records   int    `json:"records" yaml:"records"`
+	Pending   int    `json:"pending" yaml:"pending"`
+}
+
+type apiMode struct {
+	Mode Mode `json:"mode" yaml:"mode"`
+}
+
+var apiModes = map[Mode]bool{
+	ModeOff:                     true,
+	ModePlayback:                true,
+	ModeRecord:                  true,
+	ModePlaybackOrRecord:        true,
+	ModePlaybackSuccessOrRecord: true,
+	ModeRefreshStale:            true,
+}
+
+// apiRequest is a request to the API with the format negotiated for the response.
+type apiRequest struct {
+	w        http.ResponseWriter
+	req      *http.Request
+	format   Format
+	playback *Playback
+}
+
+// ServiceAPI serves the versioned API:
+//
+//	GET    /playback/v1/cassettes
+//	GET    /playback/v1/cassettes/{id}
+//	DELETE /playback/v1/cassettes/{id}
+//	GET    /playback/v1/cassettes/{id}/status
+//	PUT    /playback/v1/cassettes/{id}/mode
+//	POST   /playback/v1/cassettes/{id}/rewind
+//	POST   /playback/v1/cassettes/{id}/lock
+//	POST   /playback/v1/cassettes/{id}/unlock
+//	GET    /playback/v1/cassettes/{id}/records?kind=&key=
+//	POST   /playback/v1/cassettes/{id}/records
+//	GET    /playback/v1/cassettes/{id}/records/{recordID}
+//	PATCH  /playback/v1/cassettes/{id}/records/{recordID}
+//	DELETE /playback/v1/cassettes/{id}/records/{recordID}
+//
+// Responses are JSON, or YAML if the Accept header asks for it.
+func (h *playbackHTTPHandler) ServiceAPI(w http.ResponseWriter, req *http.Request) {
+	r := &apiRequest{w: w, req: req, format: FormatJSON, playback: h.playback}
+
+	format, ok := negotiateAPIFormat(req.Header.Get("Accept"))
+	if !ok {
+		r.fail(http.StatusNotAcceptable, "not_acceptable", "Only application/json and application/x-yaml responses are available")
+		return
+	}
+	r.format = format
+
+	path := strings.Trim(strings.TrimPrefix(req.URL.Path, APIPrefix), "/")
+	segments := strings.Split(path, "/")
+	if segments[0] != "cassettes" {
+		r.fail(http.StatusNotFound, "not_found", "Unknown API path")
+		return
+	}
+
+	if !r.authorize(apiPermission(req.Method, segments)) {
+		return
+	}
+
+	if len(segments) == 1 {
+		r.route(map[string]func(){http.MethodGet: r.listCassettes})
+		return
+	}
+
+	cassette := h.playback.Get(segments[1])
+	if cassette == nil {
+		r.fail(http.StatusNotFound, "cassette_not_found", "Cassette not found")
+		return
+	}
+
+	switch {
+	case len(segments) == 2:
+		r.route(map[string]func(){
+			http.MethodGet:    func() { r.respond(http.StatusOK, cassette.apiStatus()) },
+			http.MethodDelete: func() { r.deleteCassette(cassette) },
+		})
+	case len(segments) == 3 && segments[2] == "status":
+		r.route(map[string]func(){http.MethodGet: func() { r.respond(http.StatusOK, cassette.apiStatus()) }})
+	case len(segments) == 3 && segments[2] == "mode":
+		r.route(map[string]func(){http.MethodPut: func() { r.setMode(cassette) }})
+	case len(segments) == 3 && segments[2] == "rewind":
+		r.route(map[string]func(){http.MethodPost: func() { cassette.Rewind(); r.respond(http.StatusOK, cassette.apiStatus()) }})
+	case len(segments) == 3 && segments[2] == "lock":
+		r.route(map[string]func(){http.MethodPost: func() { cassette.Lock(); r.respond(http.StatusOK, cassette.apiStatus()) }})
+	case len(segments) == 3 && segments[2] == "unlock":
+		r.route(map[string]func(){http.MethodPost: func() { cassette.Unlock(); r.respond(http.StatusOK, cassette.apiStatus()) }})
+	case len(segments) == 3 && segments[2] == "records":
+		r.route(map[string]func(){
+			http.MethodGet:  func() { r.listRecords(cassette) },
+			http.MethodPost: func() { r.appendRecord(cassette) },
+		})
+	case len(segments) == 4 && segments[2] == "records":
+		id, err := strconv.ParseUint(segments[3], 10, 64)
+		if err != nil {
+			r.fail(http.StatusBadRequest, "invalid_record_id", "Record ID should be a number")
+			return
+		}
+		r.route(map[string]func(){
+			http.MethodGet:    func() { r.getRecord(cassette, id) },
+			http.MethodPatch:  func() { r.patchRecord(cassette, id) },
+			http.MethodDelete: func() { r.deleteRecord(cassette, id) },
+		})
+	default:
+		r.fail(http.StatusNotFound, "not_found", "Unknown API path")
+	}
+}
+
+// negotiateAPIFormat picks JSON or YAML from the Accept header.
+func negotiateAPIFormat(accept string) (Format, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return FormatJSON, true
+	}
+
+	for _, accepted := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+
+		switch mediaType {
+		case "application/json", "application/*", "*/*":
+			return FormatJSON, true
+		case "application/x-yaml", "application/yaml", "text/yaml":
+			return FormatYAML, true
+		}
+	}
+
+	return FormatJSON, false
+}
+
+// apiPermission returns the permission needed for the API call:
+// reading for GET, administering for cassette state and deletion, recording for records.
+func apiPermission(method string, segments []string) Permission {
+	if method == http.MethodGet {
+		return PermissionRead
+	}
+	if len(segments) >= 3 && (segments[2] == "records" || segments[2] == "rewind") {
+		return PermissionRecord
+	}
+
+	return PermissionAdmin
+}
+
+func (r *apiRequest) authorize(permission Permission) bool {
+	err := r.playback.authorize(httpCredentials(r.req), permission)
+	if err == ErrUnauthenticated {
+		r.w.Header().Set("WWW-Authenticate", "Bearer")
+		r.fail(http.StatusUnauthorized, "unauthenticated", err.Error())
+		return false
+	}
+	if err != nil {
+		r.fail(http.StatusForbidden, "forbidden", err.Error())
+		return false
+	}
+
+	return true
+}
+
+func (r *apiRequest) route(handlers map[string]func()) {
+	handler, ok := handlers[r.req.Method]
+	if !ok {
+		methods := make([]string, 0, len(handlers))
+		for method := range handlers {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+
+		r.w.Header().Set("Allow", strings.Join(methods, ", "))
+		r.fail(http.StatusMethodNotAllowed, "method_not_allowed", "Method "+r.req.Method+" is not allowed")
+		return
+	}
+
+	handler()
+}
+
+func (r *apiRequest) respond(status int, value interface{}) {
+	var body []byte
+	if r.format == FormatYAML {
+		body = yamlMarshal(value)
+	} else {
+		body, _ = json.Marshal(value)
+	}
+
+	r.w.Header().Set("Content-Type", formatContentTypes[r.format])
+	r.w.WriteHeader(status)
+	r.w.Write(body)
+}
+
+func (r *apiRequest) fail(status int, code, message string) {
+	r.respond(status, apiErrorBody{Error: apiError{Status: status, Code: code, Message: message}})
+}
+
+// decode reads the request body as JSON, or YAML if the Content-Type says so.
+func (r *apiRequest) decode(value interface{}) bool {
+	body, err := ioutil.ReadAll(r.req.Body)
+	if err != nil {
+		r.fail(http.StatusBadRequest, "invalid_body", err.Error())
+		return false
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.req.Header.Get("Content-Type"))
+	if strings.HasSuffix(mediaType, "yaml") {
+		err = yaml.Unmarshal(body, value)
+	} else {
+		err = json.Unmarshal(body, value)
+	}
+	if err != nil {
+		r.fail(http.StatusBadRequest, "invalid_body", err.Error())
+		return false
+	}
+
+	return true
+}
+
+func (r *apiRequest) listCassettes() {
+	cassettes := r.playback.List()
+
+	statuses := make([]apiCassetteStatus, 0, len(cassettes))
+	for _, cassette := range cassettes {
+		statuses = append(statuses, cassette.apiStatus())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].ID < statuses[j].ID })
+
+	r.respond(http.StatusOK, statuses)
+}
+
+func (r *apiRequest) deleteCassette(cassette *Cassette) {
+	r.playback.Delete(cassette.ID)
+	r.w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *apiRequest) setMode(cassette *Cassette) {
+	var mode apiMode
+	if !r.decode(&mode) {
+		return
+	}
+	if !apiModes[mode.Mode] {
+		r.fail(http.StatusBadRequest, "invalid_mode", "Mode "+string(mode.Mode)+" is unknown")
+		return
+	}
+
+	cassette.SetMode(mode.Mode)
+	r.respond(http.StatusOK, cassette.apiStatus())
+}
+
+func (r *apiRequest) listRecords(cassette *Cassette) {
+	query := r.req.URL.Query()
+	var key *string
+	if _, ok := query["key"]; ok {
+		value := query.Get("key")
+		key = &value
+	}
+
+	r.respond(http.StatusOK, cassette.apiRecords(RecordKind(query.Get("kind")), key))
+}
+
+func (r *apiRequest) appendRecord(cassette *Cassette) {
+	rec := &record{}
+	if !r.decode(rec) {
+		return
+	}
+	if rec.Kind == "" {
+		r.fail(http.StatusBadRequest, "invalid_record", "Record kind is required")
+		return
+	}
+	rec.ID = 0
+
+	err := cassette.insertRecord(rec)
+	if err == errCassetteLocked {
+		r.fail(http.StatusConflict, "cassette_locked", err.Error())
+		return
+	}
+	if err != nil {
+		r.fail(http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+
+	r.respond(http.StatusCreated, rec)
+}
+
+func (r *apiRequest) getRecord(cassette *Cassette, id uint64) {
+	rec, err := cassette.apiRecord(id)
+	if err != nil {
+		r.fail(http.StatusNotFound, "record_not_found", err.Error())
+		return
+	}
+
+	r.respond(http.StatusOK, rec)
+}
+
+// patchRecord decodes the patch onto the stored record, so a body kept in a cassette directory stays there
+// unless the patch replaces the response.
+func (r *apiRequest) patchRecord(cassette *Cassette, id uint64) {
+	rec, err := cassette.storedRecord(id)
+	if err != nil {
+		r.fail(http.StatusNotFound, "record_not_found", err.Error())
+		return
+	}
+	stored := *rec
+	if !r.decode(rec) {
+		return
+	}
+	if rec.Response != stored.Response && rec.ResponseBody == stored.ResponseBody {
+		rec.ResponseBody = ""
+	}
+
+	rec, err = cassette.replaceRecord(id, rec)
+	r.recordResult(http.StatusOK, rec, err)
+}
+
+func (r *apiRequest) deleteRecord(cassette *Cassette, id uint64) {
+	_, err := cassette.removeRecord(id)
+	if err != nil {
+		r.recordResult(0, nil, err)
+		return
+	}
+
+	r.w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *apiRequest) recordResult(status int, rec *record, err error) {
+	switch err {
+	case nil:
+		r.respond(status, rec)
+	case errRecordNotFound:
+		r.fail(http.StatusNotFound, "record_not_found", err.Error())
+	case errCassetteLocked:
+		r.fail(http.StatusConflict, "cassette_locked", err.Error())
+	default:
+		r.fail(http.StatusInternalServerError, "internal", err.Error())
+	}
+}
+
+func (c *Cassette) apiStatus() apiCassetteStatus {
+	succeeded := c.IsPlaybackSucceeded()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	status := apiCassetteStatus{
+		ID:        c.ID,
+		Name:      c.name,
+		Mode:      c.mode,
+		Locked:    c.locked,
+		Succeeded: succeeded,
+		Records:   len(c.records()),
+		Pending:   len(c.pending),
+	}
+	if c.err != nil {
+		status.Error = c.err.Error()
+	}
+
+	return status
+}
+
+// apiRecords returns copies of the records of the kind and key, all of them if empty and nil.
+func (c *Cassette) apiRecords(kind RecordKind, key *string) []*record {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	records := make([]*record, 0)
+	for _, rec := range c.resolved(sortRecords(c.records(), false)) {
+		if kind != "" && rec.Kind != kind || key != nil && rec.Key != *key {
+			continue
+		}
+		copied := *rec
+		records = append(records, &copied)
+	}
+
+	return records
+}
+
+// apiRecord returns a copy of the record with the response body loaded.
+func (c *Cassette) apiRecord(id uint64) (*record, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	track, i := c.findRecord(id)
+	if track == nil {
+		return nil, errRecordNotFound
+	}
+
+	copied := *c.resolved([]*record{track.records[i]})[0]
+	return &copied, nil
+}
+
+// storedRecord returns a copy of the record as it is stored, with the reference to its body.
+func (c *Cassette) storedRecord(id uint64) (*record, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	track, i := c.findRecord(id)
+	if track == nil {
+		return nil, errRecordNotFound
+	}
+
+	copied := *track.records[i]
+	return &copied, nil
+}
+
+func (c *Cassette) findRecord(id uint64) (*track, int) {
+	for _, kindTracks := range c.tracks {
+		for _, keyTrack := range kindTracks {
+			for i, rec := range keyTrack.records {
+				if rec.ID == id {
+					return keyTrack, i
+				}
+			}
+		}
+	}
+
+	return nil, 0
+}
+
+// insertRecord adds the record like Add, but a locked cassette rejects it
+// without failing the playback, as only the API call is wrong.
+func (c *Cassette) insertRecord(rec *record) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.locked {
+		return errCassetteLocked
+	}
+
+	return c.addLocked(rec)
+}
+
+// removeRecord takes the record out of its track and rewrites the cassette.
+func (c *Cassette) removeRecord(id uint64) (*record, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.locked {
+		return nil, errCassetteLocked
+	}
+
+	rec, err := c.removeRecordLocked(id)
+	if err != nil {
+		return nil, err
+	}
+	c.emitRecord(EventRecordDeleted, rec)
+
+	return rec, c.rewriteChanged()
+}
+
+func (c *Cassette) removeRecordLocked(id uint64) (*record, error) {
+	track, i := c.findRecord(id)
+	if track == nil {
+		return nil, errRecordNotFound
+	}
+
+	rec := track.records[i]
+	track.records = append(track.records[:i], track.records[i+1:]...)
+	if i < track.cursor {
+		track.cursor--
+	}
+	if len(track.records) == 0 {
+		delete(c.tracks[rec.Kind], rec.Key)
+		if len(c.tracks[rec.Kind]) == 0 {
+			delete(c.tracks, rec.Kind)
+		}
+	}
+	delete(c.recordByID, id)
+
+	return rec, nil
+}
+
+// replaceRecord puts the patched record in place of the one with the ID and rewrites the cassette.
+// A record moved to another kind or key is appended to its new track.
+func (c *Cassette) replaceRecord(id uint64, patched *record) (*record, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.locked {
+		return nil, errCassetteLocked
+	}
+
+	track, i := c.findRecord(id)
+	if track == nil {
+		return nil, errRecordNotFound
+	}
+
+	rec := track.records[i]
+	moved := patched.Kind != rec.Kind || patched.Key != rec.Key
+	if moved {
+		c.removeRecordLocked(id)
+	}
+
+	played := rec.played
+	*rec = *patched
+	rec.ID, rec.played, rec.cassette = id, played, c
+	if rec.Match != nil {
+		rec.Match.compile()
+	}
+
+	if moved {
+		c.add(rec)
+	}
+	c.emitRecord(EventRecordUpdated, rec)
+
+	copied := *rec
+	return &copied, c.rewriteChanged()
+}
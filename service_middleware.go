@@ -1,9 +1,12 @@
 package playback
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
 
 	yaml "gopkg.in/yaml.v2"
 )
@@ -20,10 +23,18 @@ func (p *Playback) NewPlaybackHTTPHandler() http.Handler {
 	handler := playbackHTTPHandler{playback: p}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/playback/add/", handler.ServiceAdd)
-	mux.HandleFunc("/playback/get/", handler.ServiceGet)
-	mux.HandleFunc("/playback/delete/", handler.ServiceDelete)
-	mux.HandleFunc("/playback/list/", handler.ServiceList)
+	mux.HandleFunc("/playback/add/", p.authorizedHandler(PermissionRecord, handler.ServiceAdd))
+	mux.HandleFunc("/playback/get/", p.authorizedHandler(PermissionRead, handler.ServiceGet))
+	mux.HandleFunc("/playback/delete/", p.authorizedHandler(PermissionAdmin, handler.ServiceDelete))
+	mux.HandleFunc("/playback/list/", p.authorizedHandler(PermissionRead, handler.ServiceList))
+	mux.HandleFunc("/playback/pending/", p.authorizedHandler(PermissionRead, handler.ServicePending))
+	mux.HandleFunc("/playback/approve/", p.authorizedHandler(PermissionAdmin, handler.ServiceApprove))
+	mux.HandleFunc("/playback/reject/", p.authorizedHandler(PermissionAdmin, handler.ServiceReject))
+	mux.HandleFunc("/playback/library/", p.authorizedHandler(PermissionRead, handler.ServiceLibrary))
+	mux.HandleFunc("/playback/library/reload/", p.authorizedHandler(PermissionAdmin, handler.ServiceLibraryReload))
+	mux.HandleFunc(ReportPath, p.authorizedHandler(PermissionRead, handler.ServiceReport))
+	mux.HandleFunc(EventsPath, p.authorizedHandler(PermissionRead, handler.ServiceEvents))
+	mux.HandleFunc(APIPrefix, handler.ServiceAPI)
 
 	handler.mux = mux
 
@@ -52,13 +63,34 @@ func (h *playbackHTTPHandler) ServiceAdd(w http.ResponseWriter, req *http.Reques
 		return
 	}
 
+	if cassetteID := req.URL.Query().Get("id"); cassetteID != "" {
+		h.serviceAddToCassette(w, cassetteID, body)
+		return
+	}
+
 	cassette, err := h.playback.CassetteFromYAML(body)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	fmt.Fprintf(w, cassette.ID)
+	fmt.Fprint(w, cassette.ID)
+}
+
+func (h *playbackHTTPHandler) serviceAddToCassette(w http.ResponseWriter, cassetteID string, body []byte) {
+	cassette := h.playback.Get(cassetteID)
+	if cassette == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	err := cassette.AddFromYAML(body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	fmt.Fprint(w, cassette.ID)
 }
 
 func (h *playbackHTTPHandler) ServiceGet(w http.ResponseWriter, req *http.Request) {
@@ -70,6 +102,7 @@ func (h *playbackHTTPHandler) ServiceGet(w http.ResponseWriter, req *http.Reques
 	cassetteID := req.URL.Query().Get("id")
 	if cassetteID == "" {
 		w.WriteHeader(http.StatusBadRequest)
+		return
 	}
 
 	cassette := h.playback.Get(cassetteID)
@@ -78,7 +111,30 @@ func (h *playbackHTTPHandler) ServiceGet(w http.ResponseWriter, req *http.Reques
 		return
 	}
 
-	w.Write(cassette.MarshalToYAML())
+	format, err := requestedFormat(req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", formatContentTypes[format])
+	w.Write(cassette.MarshalTo(format))
+}
+
+// requestedFormat picks the format from the format query parameter or the Accept header.
+func requestedFormat(req *http.Request) (Format, error) {
+	if name := req.URL.Query().Get("format"); name != "" {
+		return ParseFormat(name)
+	}
+
+	accept := req.Header.Get("Accept")
+	for _, format := range []Format{FormatJSONL, FormatJSON} {
+		if strings.Contains(accept, formatContentTypes[format]) {
+			return format, nil
+		}
+	}
+
+	return FormatYAML, nil
 }
 
 func (h *playbackHTTPHandler) ServiceDelete(w http.ResponseWriter, req *http.Request) {
@@ -116,3 +172,179 @@ func (h *playbackHTTPHandler) ServiceList(w http.ResponseWriter, req *http.Reque
 	encoder.Encode(cassetteIDs)
 	encoder.Close()
 }
+
+func (h *playbackHTTPHandler) ServiceLibrary(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	names := h.playback.Library()
+	if names == nil {
+		names = []string{}
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.Encode(names)
+	encoder.Close()
+}
+
+func (h *playbackHTTPHandler) ServiceLibraryReload(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err := h.playback.ReloadLibrary()
+	if err == errLibraryNotSet {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err)
+		return
+	}
+}
+
+// ServiceReport answers the playback report of the cassette as JSON, or as YAML if the Accept header asks for it.
+func (h *playbackHTTPHandler) ServiceReport(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	format, ok := negotiateAPIFormat(req.Header.Get("Accept"))
+	if !ok {
+		w.WriteHeader(http.StatusNotAcceptable)
+		return
+	}
+
+	cassette, ok := h.cassetteFromQuery(w, req)
+	if !ok {
+		return
+	}
+
+	r := &apiRequest{w: w, req: req, format: format, playback: h.playback}
+	r.respond(http.StatusOK, cassette.Report())
+}
+
+// ServiceEvents streams the events as Server-Sent Events, filtered by the id and the comma separated kind query parameters.
+func (h *playbackHTTPHandler) ServiceEvents(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	filter := EventFilter{CassetteID: req.URL.Query().Get("id")}
+	if kinds := req.URL.Query().Get("kind"); kinds != "" {
+		for _, kind := range strings.Split(kinds, ",") {
+			filter.Kinds = append(filter.Kinds, RecordKind(strings.TrimSpace(kind)))
+		}
+	}
+
+	subscription := h.playback.Subscribe(filter)
+	defer subscription.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case event := <-subscription.Events():
+			data, _ := json.Marshal(event)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			flusher.Flush()
+		}
+	}
+}
+
+func (h *playbackHTTPHandler) ServicePending(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	cassette, ok := h.cassetteFromQuery(w, req)
+	if !ok {
+		return
+	}
+
+	w.Write(cassette.MarshalPendingToYAML())
+}
+
+func (h *playbackHTTPHandler) ServiceApprove(w http.ResponseWriter, req *http.Request) {
+	h.serviceReview(w, req, (*Cassette).Approve)
+}
+
+func (h *playbackHTTPHandler) ServiceReject(w http.ResponseWriter, req *http.Request) {
+	h.serviceReview(w, req, (*Cassette).Reject)
+}
+
+func (h *playbackHTTPHandler) serviceReview(w http.ResponseWriter, req *http.Request, review func(*Cassette, ...uint64) error) {
+	if req.Method != "POST" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	cassette, ok := h.cassetteFromQuery(w, req)
+	if !ok {
+		return
+	}
+
+	ids, err := parseRecordIDs(req.URL.Query().Get("record"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = review(cassette, ids...)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *playbackHTTPHandler) cassetteFromQuery(w http.ResponseWriter, req *http.Request) (*Cassette, bool) {
+	cassetteID := req.URL.Query().Get("id")
+	if cassetteID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+
+	cassette := h.playback.Get(cassetteID)
+	if cassette == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return nil, false
+	}
+
+	return cassette, true
+}
+
+// parseRecordIDs parses a comma separated list of record IDs.
+func parseRecordIDs(list string) ([]uint64, error) {
+	if list == "" {
+		return nil, nil
+	}
+
+	var ids []uint64
+	for _, field := range strings.Split(list, ",") {
+		id, err := strconv.ParseUint(strings.TrimSpace(field), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql/driver"
 	"fmt"
+	"time"
 )
 
 type sqlResultRecorder struct {
@@ -89,7 +90,9 @@ func (r *sqlResultRecorder) record(ctx context.Context, query string, namedValue
 
 	r.rec.RecordRequest()
 
+	start := time.Now()
 	result, err := r.call(ctx, query)
+	r.rec.Duration = time.Since(start)
 
 	r.RecordResponse(result, err)
 	r.rec.PanicIfHas()
@@ -135,6 +138,18 @@ func (r *sqlResultRecorder) playback(ctx context.Context, query string, namedVal
 	return result, rec.Err.error
 }
 
+func (r *sqlResultRecorder) lookup() *record {
+	return r.rec
+}
+
+func (r *sqlResultRecorder) callContext() context.Context {
+	return r.ctx
+}
+
+func (r *sqlResultRecorder) fail(err error) {
+	r.result, r.err = nil, err
+}
+
 func (r *sqlResultRecorder) newRecord(ctx context.Context, query string) *record {
 	requestDump := fmt.Sprintf("%s\n%#v\n%#v\n", query, r.namedValues, r.values)
 
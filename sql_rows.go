@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql/driver"
 	"fmt"
+	"time"
 )
 
 type SQLRowsRecorder struct {
@@ -89,7 +90,9 @@ func (r *SQLRowsRecorder) record(ctx context.Context, query string) (driver.Rows
 
 	r.rec.RecordRequest()
 
+	start := time.Now()
 	rows, err := r.call(ctx, query)
+	r.rec.Duration = time.Since(start)
 
 	r.RecordResponse(rows, err)
 	r.rec.PanicIfHas()
@@ -135,6 +138,18 @@ func (r *SQLRowsRecorder) playback(ctx context.Context, query string) (driver.Ro
 	return rows, rec.Err.error
 }
 
+func (r *SQLRowsRecorder) lookup() *record {
+	return r.rec
+}
+
+func (r *SQLRowsRecorder) callContext() context.Context {
+	return r.ctx
+}
+
+func (r *SQLRowsRecorder) fail(err error) {
+	r.rows, r.err = nil, err
+}
+
 func (r *SQLRowsRecorder) newRecord(ctx context.Context, query string) *record {
 	request := query
 	if len(r.namedValues) > 0 {
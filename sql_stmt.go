@@ -3,6 +3,7 @@ package playback
 import (
 	"context"
 	"database/sql/driver"
+	"time"
 )
 
 type SQLStmtRecorder struct {
@@ -61,7 +62,9 @@ func (r *SQLStmtRecorder) record(ctx context.Context, query string) (driver.Stmt
 
 	r.rec.RecordRequest()
 
+	start := time.Now()
 	stmt, err := r.call(ctx, query)
+	r.rec.Duration = time.Since(start)
 
 	r.RecordResponse(ctx, stmt, err)
 	r.rec.PanicIfHas()
@@ -107,6 +110,18 @@ func (r *SQLStmtRecorder) playback(ctx context.Context, query string) (driver.St
 	return stmt, rec.Err.error
 }
 
+func (r *SQLStmtRecorder) lookup() *record {
+	return r.rec
+}
+
+func (r *SQLStmtRecorder) callContext() context.Context {
+	return r.ctx
+}
+
+func (r *SQLStmtRecorder) fail(err error) {
+	r.stmt, r.err = nil, err
+}
+
 func (r *SQLStmtRecorder) newRecord(ctx context.Context, query string) *record {
 	r.rec = &record{
 		Kind:     KindSQLStmt,
@@ -0,0 +1,361 @@
+package playback
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrStoreNotFound is returned by stores when no cassette is saved under the name.
+	ErrStoreNotFound = errors.New("Cassette not found in store")
+	errStoreName     = errors.New("Cassette name is invalid")
+	errStoreUnknown  = errors.New("Store is not registered for the path type")
+)
+
+// Store keeps cassette dumps by name.
+// Stores are registered on Playback by path type and selected with the x-playback-path-type header.
+type Store interface {
+	Save(name string, dump []byte) error
+	Load(name string) ([]byte, error)
+	List() ([]string, error)
+	Delete(name string) error
+}
+
+// DirStore keeps cassettes as files under a root directory.
+// Names are slash separated paths relative to the root.
+type DirStore struct {
+	root string
+}
+
+func NewDirStore(root string) *DirStore {
+	return &DirStore{root: root}
+}
+
+func (s *DirStore) Root() string {
+	return s.root
+}
+
+// path resolves the name inside the root. Symlinks leading out of the root are rejected.
+func (s *DirStore) path(name string) (string, error) {
+	cleaned := filepath.Clean("/" + filepath.FromSlash(name))
+	if name == "" || cleaned == string(filepath.Separator) {
+		return "", errStoreName
+	}
+
+	return confinedPath(s.root, filepath.Join(s.root, cleaned))
+}
+
+func (s *DirStore) Save(name string, dump []byte) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(dump)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *DirStore) Load(name string) ([]byte, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	dump, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrStoreNotFound
+	}
+
+	return dump, err
+}
+
+// List returns the cassette files and, like FSStore, the cassette directories without their contents.
+func (s *DirStore) List() ([]string, error) {
+	var names []string
+	err := filepath.Walk(s.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		isCassetteDir := false
+		if info.IsDir() && path != s.root {
+			_, err := openCassetteDir(path)
+			isCassetteDir = err == nil
+		}
+		if !info.Mode().IsRegular() && !isCassetteDir {
+			return nil
+		}
+
+		name, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(name))
+
+		if isCassetteDir {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	return names, err
+}
+
+func (s *DirStore) Delete(name string) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return ErrStoreNotFound
+	}
+
+	return err
+}
+
+// MemoryStore keeps cassettes in memory. Nothing is evicted,
+// so it should only be registered for callers trusted with the memory it takes.
+type MemoryStore struct {
+	dumps map[string][]byte
+	mu    sync.RWMutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{dumps: make(map[string][]byte)}
+}
+
+func (s *MemoryStore) Save(name string, dump []byte) error {
+	if name == "" {
+		return errStoreName
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.dumps[name] = append([]byte(nil), dump...)
+
+	return nil
+}
+
+func (s *MemoryStore) Load(name string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	dump, ok := s.dumps[name]
+	if !ok {
+		return nil, ErrStoreNotFound
+	}
+
+	return append([]byte(nil), dump...), nil
+}
+
+func (s *MemoryStore) List() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := make([]string, 0, len(s.dumps))
+	for name := range s.dumps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func (s *MemoryStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.dumps[name]; !ok {
+		return ErrStoreNotFound
+	}
+	delete(s.dumps, name)
+
+	return nil
+}
+
+// storeWriter keeps the cassette dump written so far and saves it to the store on Sync and Close,
+// so recording doesn't save the whole dump again for every record.
+type storeWriter struct {
+	store Store
+	typ   PathType
+	name  string
+	buf   bytes.Buffer
+	dirty bool
+}
+
+func newStoreWriter(store Store, typ PathType, name string) *storeWriter {
+	return &storeWriter{
+		store: store,
+		typ:   typ,
+		name:  name,
+	}
+}
+
+func (w *storeWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	w.dirty = true
+
+	return len(p), nil
+}
+
+func (w *storeWriter) Sync() error {
+	if !w.dirty {
+		return nil
+	}
+
+	err := w.store.Save(w.name, w.buf.Bytes())
+	if err != nil {
+		return err
+	}
+	w.dirty = false
+
+	return nil
+}
+
+func (w *storeWriter) Close() error {
+	return w.Sync()
+}
+
+func (w *storeWriter) Name() string {
+	return w.name
+}
+
+func (w *storeWriter) Type() PathType {
+	return w.typ
+}
+
+func (*storeWriter) ReadOnly() bool {
+	return false
+}
+
+func (w *storeWriter) Truncate(size int64) error {
+	w.buf.Truncate(int(size))
+	w.dirty = true
+
+	return nil
+}
+
+func (*storeWriter) Seek(offset int64, whence int) (int64, error) {
+	return offset, nil
+}
+
+// replace saves the dump in place of the written one.
+func (w *storeWriter) replace(dump []byte) error {
+	w.buf.Reset()
+	w.buf.Write(dump)
+	w.dirty = true
+
+	return w.Sync()
+}
+
+// storeName returns the name of a new cassette in a store, following the file mask.
+func (c *Cassette) storeName() string {
+	mask := strings.TrimSuffix(c.playback.fileMask, compressedSuffix)
+
+	return strings.Replace(fileMaskForFormat(mask, c.format, false), "*", c.ID, 1)
+}
+
+// WithStore makes the cassette save its records to the store registered for the path type.
+// A name is generated from the file mask if none is given.
+func (c *Cassette) WithStore(pathType PathType, name string) (*Cassette, error) {
+	store := c.playback.Store(pathType)
+	if store == nil {
+		return c, errStoreUnknown
+	}
+	if _, ok := store.(*FSStore); ok {
+		return c, errStoreReadOnly
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if name == "" {
+		name = c.storeName()
+	}
+
+	c.writer = newStoreWriter(store, pathType, name)
+
+	err := c.write(string(marshalFileStart(c.format, c.header)))
+	return c, err
+}
+
+func newCassetteFromStore(p *Playback, pathType PathType, name string) (*Cassette, error) {
+	store := p.Store(pathType)
+	if store == nil {
+		return nil, errStoreUnknown
+	}
+
+	c, err := loadCassetteFromStore(p, store, name)
+	if err != nil {
+		return c, err
+	}
+
+	c.writer = newNilNamed(pathType, name)
+
+	return c, nil
+}
+
+func loadCassetteFromStore(p *Playback, store Store, name string) (*Cassette, error) {
+	if fsStore, ok := store.(*FSStore); ok {
+		return newCassetteFromFS(p, fsStore.FS(), name)
+	}
+	if fsys, _, ok := storeCassetteDir(store, name); ok {
+		return newCassetteFromFS(p, fsys, name)
+	}
+
+	dump, err := store.Load(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return newCassetteFromYAML(p, dump)
+}
+
+// storeHas tells whether a cassette is saved under the name, as a dump or a cassette directory.
+func storeHas(store Store, name string) bool {
+	if fsStore, ok := store.(*FSStore); ok {
+		_, err := fs.Stat(fsStore.FS(), name)
+		return err == nil
+	}
+	if _, _, ok := storeCassetteDir(store, name); ok {
+		return true
+	}
+
+	_, err := store.Load(name)
+
+	return err == nil
+}
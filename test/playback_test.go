@@ -1,11 +1,16 @@
 package test
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"crypto/md5"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"database/sql"
 	"database/sql/driver"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -17,11 +22,14 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	pb "cloud.google.com/go/trace/testdata/helloworld"
@@ -33,6 +41,7 @@ import (
 	"google.golang.org/grpc/test/bufconn"
 
 	"github.com/wtertius/playback"
+	"github.com/wtertius/playback/boltstore"
 	"github.com/wtertius/playback/httphelper"
 	yaml "gopkg.in/yaml.v2"
 )
@@ -402,7 +411,6 @@ func TestCassete(t *testing.T) {
 				key := "rand.Intn"
 				f := func() int {
 					panic("PANIC")
-					return rand.Intn(randRange)
 				}
 
 				func() {
@@ -452,7 +460,8 @@ func TestCassete(t *testing.T) {
 			key := "rand.Intn"
 			numberExpected := cassette.Result(key, rand.Intn(randRange)).(int)
 
-			contentsExpected := "- kind: result\n" +
+			contentsExpected := cassetteHeader +
+				"- kind: result\n" +
 				"  key: rand.Intn\n" +
 				"  id: 1\n" +
 				"  requestmeta: \"\"\n" +
@@ -461,63 +470,2479 @@ func TestCassete(t *testing.T) {
 				"  response: |\n" +
 				"    " + strconv.Itoa(numberExpected) + "\n" +
 				"  err: null\n" +
-				"  panic: null\n"
+				"  panic: null\n" +
+				"  created_at: <created_at>\n"
 			contentsGot, err := ioutil.ReadFile(cassette.PathName())
 			if err != nil {
 				t.Fatal(err)
 			}
 
+			contentsGot = normalizeCreatedAt(contentsGot)
+
 			assert.Equal(t, contentsExpected, string(contentsGot))
 		})
 	})
 
-	t.Run("playback.ResultWithError: record and playback", func(t *testing.T) {
-		rand.Seed(time.Now().Unix())
-		randRange := 100
+	t.Run("playback.ResultWithError: record and playback", func(t *testing.T) {
+		rand.Seed(time.Now().Unix())
+		randRange := 100
+
+		t.Run("func", func(t *testing.T) {
+			t.Run("success", func(t *testing.T) {
+				p := playback.New().WithFile().SetDefaultMode(playback.ModeRecord)
+				cassette, _ := p.NewCassette()
+				defer removeFilename(t, cassette.PathName())
+
+				key := "rand.Intn"
+				f := func() (interface{}, error) { return rand.Intn(randRange), nil }
+
+				numberExpected, err := cassette.ResultWithError(key, f)
+
+				assert.Nil(t, err)
+				assert.NotEmpty(t, numberExpected)
+
+				cassette, _ = p.CassetteFromFile(cassette.PathName())
+
+				numberGot, err := cassette.ResultWithError(key, f)
+
+				assert.Nil(t, err)
+				assert.Equal(t, numberExpected, numberGot)
+				assert.True(t, cassette.IsPlaybackSucceeded())
+			})
+			t.Run("with error", func(t *testing.T) {
+				p := playback.New().WithFile().SetDefaultMode(playback.ModeRecord)
+				cassette, _ := p.NewCassette()
+				defer removeFilename(t, cassette.PathName())
+
+				key := "rand.Intn"
+				f := func() (interface{}, error) { return 0, fmt.Errorf("Failed") }
+
+				numberExpected, errExpected := cassette.ResultWithError(key, f)
+
+				assert.Error(t, errExpected)
+				assert.Empty(t, numberExpected)
+
+				cassette, _ = p.CassetteFromFile(cassette.PathName())
+
+				numberGot, errGot := cassette.ResultWithError(key, f)
+
+				assert.Equal(t, numberExpected, numberGot)
+				assert.Equal(t, errExpected, errGot)
+				assert.True(t, cassette.IsPlaybackSucceeded())
+			})
+		})
+	})
+
+	t.Run("strict order", func(t *testing.T) {
+		record := func(p *playback.Playback) *playback.Cassette {
+			cassette, _ := p.NewCassette()
+			cassette.SetMode(playback.ModeRecord)
+			cassette.Result("order", 1)
+			cassette.Result("payment", 2)
+			cassette.SetMode(playback.ModePlayback)
+
+			return cassette
+		}
+
+		t.Run("replays in recorded order", func(t *testing.T) {
+			cassette := record(playback.New().SetStrictOrder(true))
+
+			assert.Equal(t, 1, cassette.Result("order", 0))
+			assert.Equal(t, 2, cassette.Result("payment", 0))
+			assert.True(t, cassette.IsPlaybackSucceeded())
+		})
+		t.Run("reports the first divergence", func(t *testing.T) {
+			cassette := record(playback.New().SetStrictOrder(true))
+
+			assert.Equal(t, 0, cassette.Result("payment", 0))
+			assert.Equal(t, 1, cassette.Result("order", 0))
+			assert.Equal(t, 2, cassette.Result("payment", 0))
+			assert.False(t, cassette.IsPlaybackSucceeded())
+
+			err, ok := cassette.Error().(*playback.OrderError)
+			if !assert.True(t, ok) {
+				return
+			}
+			assert.Equal(t, playback.RecordRef{ID: 1, Kind: playback.KindResult, Key: "order"}, err.Expected)
+			assert.Equal(t, playback.RecordRef{ID: 2, Kind: playback.KindResult, Key: "payment"}, err.Got)
+		})
+		t.Run("ignores order if off", func(t *testing.T) {
+			cassette := record(playback.New())
+
+			assert.Equal(t, 2, cassette.Result("payment", 0))
+			assert.Equal(t, 1, cassette.Result("order", 0))
+			assert.True(t, cassette.IsPlaybackSucceeded())
+		})
+	})
+
+	t.Run("replay policy", func(t *testing.T) {
+		record := func(values ...int) *playback.Cassette {
+			cassette, _ := playback.New().NewCassette()
+			cassette.SetMode(playback.ModeRecord)
+			for _, value := range values {
+				cassette.Result("config", value)
+			}
+			cassette.SetMode(playback.ModePlayback)
+
+			return cassette
+		}
+
+		t.Run("once is consumed by a single call", func(t *testing.T) {
+			cassette := record(1)
+
+			assert.Equal(t, 1, cassette.Result("config", 0))
+			assert.Equal(t, 0, cassette.Result("config", 0))
+		})
+		t.Run("sticky is replayed forever", func(t *testing.T) {
+			cassette := record(1)
+			assert.Nil(t, cassette.SetReplayPolicy(playback.KindResult, "config", playback.ReplayPolicy{Mode: playback.ReplaySticky}))
+
+			for i := 0; i < 5; i++ {
+				assert.Equal(t, 1, cassette.Result("config", 0))
+			}
+			assert.True(t, cassette.IsPlaybackSucceeded())
+		})
+		t.Run("repeat is replayed up to N times", func(t *testing.T) {
+			cassette := record(1, 2)
+			assert.Nil(t, cassette.SetReplayPolicy(playback.KindResult, "config", playback.ReplayPolicy{Mode: playback.ReplayRepeat, Times: 2}))
+
+			assert.Equal(t, 1, cassette.Result("config", 0))
+			assert.Equal(t, 1, cassette.Result("config", 0))
+			assert.Equal(t, 2, cassette.Result("config", 0))
+			assert.True(t, cassette.IsPlaybackSucceeded())
+		})
+		t.Run("repeat replayed fewer times succeeds", func(t *testing.T) {
+			cassette := record(1)
+			assert.Nil(t, cassette.SetReplayPolicy(playback.KindResult, "config", playback.ReplayPolicy{Mode: playback.ReplayRepeat, Times: 3}))
+
+			assert.Equal(t, 1, cassette.Result("config", 0))
+			assert.True(t, cassette.IsPlaybackSucceeded())
+		})
+		t.Run("unordered is matched by args and ignored by strict order", func(t *testing.T) {
+			query := `INSERT INTO posts ("id") VALUES (?)`
+			p := playback.New().SetStrictOrder(true)
+			cassette, _ := p.NewCassette()
+			ctx := playback.NewContextWithCassette(context.Background(), cassette)
+
+			driverName, dsn := p.SQLNameAndDSN("sqlmock", t.Name())
+			_, mock, _ := sqlmock.NewWithDSN(dsn)
+			db, _ := sql.Open(driverName, dsn)
+			defer db.Close()
+			insert := func(id int64) int64 {
+				result, err := db.ExecContext(ctx, query, id)
+				if !assert.Nil(t, err) {
+					return 0
+				}
+				lastInsertId, _ := result.LastInsertId()
+				return lastInsertId
+			}
+
+			cassette.SetMode(playback.ModeRecord)
+			for _, id := range []int64{1, 2} {
+				mock.ExpectExec("^INSERT INTO posts").WithArgs(id).WillReturnResult(sqlmock.NewResult(id, 1))
+				insert(id)
+			}
+			cassette.Result("order", 3)
+			cassette.SetMode(playback.ModePlayback)
+			assert.Nil(t, cassette.SetReplayPolicy(playback.KindSQLResult, query, playback.ReplayPolicy{Mode: playback.ReplayUnordered}))
+
+			assert.Equal(t, 3, cassette.Result("order", 0))
+			assert.Equal(t, int64(2), insert(2))
+			assert.Equal(t, int64(1), insert(1))
+			assert.True(t, cassette.IsPlaybackSucceeded())
+		})
+		t.Run("unordered isn't supported by keys without args", func(t *testing.T) {
+			cassette := record(1, 2)
+
+			assert.Equal(t, playback.ErrReplayUnsupported, cassette.SetReplayPolicy(playback.KindResult, "config", playback.ReplayPolicy{Mode: playback.ReplayUnordered}))
+			assert.Equal(t, playback.ErrReplayUnsupported, cassette.SetRecordReplayPolicy(1, playback.ReplayPolicy{Mode: playback.ReplayUnordered}))
+		})
+		t.Run("can be set per record", func(t *testing.T) {
+			cassette := record(1, 2)
+			assert.Nil(t, cassette.SetRecordReplayPolicy(2, playback.ReplayPolicy{Mode: playback.ReplayRepeat, Times: 2}))
+			assert.NotNil(t, cassette.SetRecordReplayPolicy(3, playback.ReplayPolicy{Mode: playback.ReplaySticky}))
+
+			assert.Equal(t, 1, cassette.Result("config", 0))
+			assert.Equal(t, 2, cassette.Result("config", 0))
+			assert.Equal(t, 2, cassette.Result("config", 0))
+			assert.Equal(t, 0, cassette.Result("config", 0))
+		})
+		t.Run("can be set in yaml", func(t *testing.T) {
+			dump := "" +
+				"- kind: result\n" +
+				"  key: config\n" +
+				"  id: 1\n" +
+				"  responsemeta: int\n" +
+				"  response: |\n" +
+				"    7\n" +
+				"  replay:\n" +
+				"    mode: sticky\n"
+
+			cassette, err := playback.New().CassetteFromYAML([]byte(dump))
+			assert.Nil(t, err)
+
+			assert.Equal(t, 7, cassette.Result("config", 0))
+			assert.Equal(t, 7, cassette.Result("config", 0))
+			assert.True(t, cassette.IsPlaybackSucceeded())
+		})
+		t.Run("is reset by rewind", func(t *testing.T) {
+			cassette := record(1)
+			assert.Equal(t, 1, cassette.Result("config", 0))
+
+			cassette.Rewind()
+			assert.False(t, cassette.IsPlaybackSucceeded())
+			assert.Equal(t, 1, cassette.Result("config", 0))
+		})
+	})
+
+	t.Run("miss policy", func(t *testing.T) {
+		calls := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			fmt.Fprint(w, "real")
+		}))
+		defer ts.Close()
+
+		newPlayback := func(kind playback.RecordKind, policy playback.MissPolicy) (*playback.Cassette, *http.Client) {
+			p := playback.New().SetMissPolicy(kind, policy)
+			cassette, _ := p.NewCassette()
+			cassette.SetMode(playback.ModePlayback)
+
+			return cassette, &http.Client{Transport: p.HTTPTransport(http.DefaultTransport)}
+		}
+		get := func(cassette *playback.Cassette, httpClient *http.Client) (*http.Response, error) {
+			req, _ := http.NewRequest("GET", ts.URL, nil)
+			req = req.WithContext(playback.NewContextWithCassette(req.Context(), cassette))
+			return httpClient.Do(req)
+		}
+
+		t.Run("fails by default", func(t *testing.T) {
+			cassette, httpClient := newPlayback(playback.KindHTTP, playback.MissPolicy{})
+
+			_, err := get(cassette, httpClient)
+			assert.Equal(t, &url.Error{Op: "Get", URL: ts.URL, Err: playback.ErrPlaybackFailed}, err)
+		})
+		t.Run("responds with a synthetic response", func(t *testing.T) {
+			cassette, httpClient := newPlayback(playback.KindHTTP, playback.MissPolicy{Action: playback.MissRespond, StatusCode: http.StatusServiceUnavailable})
+
+			res, err := get(cassette, httpClient)
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+
+			body, _ := ioutil.ReadAll(res.Body)
+			assert.Contains(t, string(body), "Playback miss in cassette "+cassette.ID)
+			assert.False(t, cassette.IsPlaybackSucceeded())
+		})
+		t.Run("returns a chosen error", func(t *testing.T) {
+			errMiss := fmt.Errorf("miss")
+			cassette, _ := newPlayback(playback.KindResult, playback.MissPolicy{Action: playback.MissError, Err: errMiss})
+
+			value, err := cassette.ResultWithError("config", 1)
+			assert.Equal(t, 0, value)
+			assert.Equal(t, errMiss, err)
+		})
+		t.Run("panics with a diagnostic", func(t *testing.T) {
+			cassette, _ := newPlayback(playback.KindResult, playback.MissPolicy{Action: playback.MissPanic})
+
+			assert.PanicsWithValue(t, "Playback miss in cassette "+cassette.ID+": no result record matches key \"config\"", func() {
+				cassette.Result("config", 1)
+			})
+		})
+		t.Run("calls through without recording", func(t *testing.T) {
+			cassette, httpClient := newPlayback(playback.KindHTTP, playback.MissPolicy{Action: playback.MissCallThrough})
+			callsBefore := calls
+
+			res, err := get(cassette, httpClient)
+			assert.Nil(t, err)
+
+			body, _ := ioutil.ReadAll(res.Body)
+			assert.Equal(t, "real", string(body))
+			assert.Equal(t, callsBefore+1, calls)
+			assert.Empty(t, cassette.Keys())
+		})
+		t.Run("blocks until a record is added through the service", func(t *testing.T) {
+			p := playback.New().SetMissPolicy(playback.KindResult, playback.MissPolicy{Action: playback.MissBlock, Timeout: time.Second})
+			cassette, _ := p.NewCassette()
+			cassette.SetMode(playback.ModePlayback)
+			handler := p.NewPlaybackHTTPHandler()
+
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+
+				recorded, _ := playback.New().NewCassette()
+				recorded.AddResultRecord("config", "", 7, nil, nil)
+
+				req := httptest.NewRequest("POST", "http://example.com/playback/add/?id="+cassette.ID, bytes.NewBuffer(recorded.MarshalToYAML()))
+				handler.ServeHTTP(httptest.NewRecorder(), req)
+			}()
+
+			assert.Equal(t, 7, cassette.Result("config", 0))
+			assert.True(t, cassette.IsPlaybackSucceeded())
+		})
+		t.Run("block gives up after timeout", func(t *testing.T) {
+			cassette, _ := newPlayback(playback.KindResult, playback.MissPolicy{Action: playback.MissBlock, Timeout: time.Millisecond})
+
+			assert.Equal(t, 0, cassette.Result("config", 1))
+			assert.False(t, cassette.IsPlaybackSucceeded())
+		})
+		t.Run("isn't applied to a record of another type", func(t *testing.T) {
+			cassette, _ := newPlayback(playback.KindResult, playback.MissPolicy{Action: playback.MissPanic})
+			cassette.AddResultRecord("config", "", "seven", nil, nil)
+
+			var value interface{}
+			assert.NotPanics(t, func() {
+				value = cassette.Result("config", 1)
+			})
+			assert.Equal(t, 0, value)
+		})
+	})
+
+	t.Run("latency", func(t *testing.T) {
+		delay := 30 * time.Millisecond
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(delay)
+			fmt.Fprint(w, "slow")
+		}))
+		defer ts.Close()
+
+		record := func(p *playback.Playback) (*playback.Cassette, *http.Client) {
+			httpClient := &http.Client{Transport: p.HTTPTransport(http.DefaultTransport)}
+			cassette, _ := p.NewCassette()
+			cassette.SetMode(playback.ModeRecord)
+
+			req, _ := http.NewRequest("GET", ts.URL, nil)
+			req = req.WithContext(playback.NewContextWithCassette(req.Context(), cassette))
+			httpClient.Do(req)
+
+			cassette.SetMode(playback.ModePlayback)
+
+			return cassette, httpClient
+		}
+		replay := func(ctx context.Context, cassette *playback.Cassette, httpClient *http.Client) (time.Duration, error) {
+			req, _ := http.NewRequest("GET", ts.URL, nil)
+			req = req.WithContext(playback.NewContextWithCassette(ctx, cassette))
+
+			start := time.Now()
+			_, err := httpClient.Do(req)
+
+			return time.Since(start), err
+		}
+
+		t.Run("duration is recorded", func(t *testing.T) {
+			cassette, _ := record(playback.New())
+
+			var file struct {
+				Records []struct{ Duration time.Duration }
+			}
+			yaml.Unmarshal(cassette.MarshalToYAML(), &file)
+
+			assert.Len(t, file.Records, 1)
+			assert.True(t, file.Records[0].Duration >= delay)
+		})
+		t.Run("replays instantly by default", func(t *testing.T) {
+			cassette, httpClient := record(playback.New())
+
+			elapsed, err := replay(context.Background(), cassette, httpClient)
+			assert.Nil(t, err)
+			assert.True(t, elapsed < delay)
+		})
+		t.Run("reproduces original latency", func(t *testing.T) {
+			cassette, httpClient := record(playback.New().SetLatency(playback.LatencyOriginal))
+
+			elapsed, err := replay(context.Background(), cassette, httpClient)
+			assert.Nil(t, err)
+			assert.True(t, elapsed >= delay)
+		})
+		t.Run("caps latency", func(t *testing.T) {
+			cassette, httpClient := record(playback.New().SetLatency(playback.Latency{Factor: 10, Max: time.Millisecond}))
+
+			elapsed, err := replay(context.Background(), cassette, httpClient)
+			assert.Nil(t, err)
+			assert.True(t, elapsed < delay)
+		})
+		t.Run("respects context deadline", func(t *testing.T) {
+			cassette, httpClient := record(playback.New().SetLatency(playback.LatencyOriginal))
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+			defer cancel()
+
+			_, err := replay(ctx, cassette, httpClient)
+			if assert.IsType(t, &url.Error{}, err) {
+				assert.Equal(t, context.DeadlineExceeded, err.(*url.Error).Err)
+			}
+		})
+	})
+
+	t.Run("fault injection", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "recorded body")
+		}))
+		defer ts.Close()
+
+		record := func(faults playback.Faults) (*playback.Cassette, func() (*http.Response, error)) {
+			p := playback.New().SetFaults(faults)
+			httpClient := &http.Client{Transport: p.HTTPTransport(http.DefaultTransport)}
+			cassette, _ := p.NewCassette()
+
+			get := func() (*http.Response, error) {
+				req, _ := http.NewRequest("GET", ts.URL, nil)
+				req = req.WithContext(playback.NewContextWithCassette(req.Context(), cassette))
+				return httpClient.Do(req)
+			}
+
+			cassette.SetMode(playback.ModeRecord)
+			for i := 0; i < 10; i++ {
+				get()
+			}
+			cassette.Result("config", 1)
+			cassette.SetMode(playback.ModePlayback)
+
+			return cassette, get
+		}
+
+		t.Run("replaces response with 5xx", func(t *testing.T) {
+			cassette, get := record(playback.Faults{Rules: []playback.FaultRule{{
+				Kind:       playback.KindHTTP,
+				Fault:      playback.FaultStatus,
+				StatusCode: http.StatusBadGateway,
+			}}})
+
+			res, err := get()
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusBadGateway, res.StatusCode)
+			assert.Len(t, cassette.InjectedFaults(), 1)
+		})
+		t.Run("truncates body", func(t *testing.T) {
+			_, get := record(playback.Faults{Rules: []playback.FaultRule{{Fault: playback.FaultTruncate}}})
+
+			res, err := get()
+			assert.Nil(t, err)
+
+			body, err := ioutil.ReadAll(res.Body)
+			assert.Equal(t, io.ErrUnexpectedEOF, err)
+			assert.Equal(t, "record", string(body))
+		})
+		t.Run("replaces result with error by key", func(t *testing.T) {
+			cassette, get := record(playback.Faults{Rules: []playback.FaultRule{{
+				Key:   regexp.MustCompile("^config$"),
+				Fault: playback.FaultError,
+			}}})
+
+			_, err := get()
+			assert.Nil(t, err)
+
+			value, err := cassette.ResultWithError("config", 0)
+			assert.Equal(t, 0, value)
+			assert.Equal(t, playback.ErrFaultInjected, err)
+
+			injected := cassette.InjectedFaults()
+			if assert.Len(t, injected, 1) {
+				assert.Equal(t, 2, injected[0].Call)
+				assert.Equal(t, playback.KindResult, injected[0].Record.Kind)
+			}
+		})
+		t.Run("panics", func(t *testing.T) {
+			cassette, _ := record(playback.Faults{Rules: []playback.FaultRule{{Kind: playback.KindResult, Fault: playback.FaultPanic}}})
+
+			assert.Panics(t, func() {
+				cassette.Result("config", 0)
+			})
+		})
+		t.Run("slows down respecting context", func(t *testing.T) {
+			cassette, _ := record(playback.Faults{Rules: []playback.FaultRule{{Kind: playback.KindHTTP, Fault: playback.FaultSlow, Delay: time.Second}}})
+			httpClient := &http.Client{Transport: playback.New().HTTPTransport(http.DefaultTransport)}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+			defer cancel()
+
+			req, _ := http.NewRequest("GET", ts.URL, nil)
+			req = req.WithContext(playback.NewContextWithCassette(ctx, cassette))
+			_, err := httpClient.Do(req)
+			if assert.IsType(t, &url.Error{}, err) {
+				assert.Equal(t, context.DeadlineExceeded, err.(*url.Error).Err)
+			}
+		})
+		t.Run("same seed injects same faults", func(t *testing.T) {
+			faults := playback.Faults{Seed: 42, Rules: []playback.FaultRule{{Probability: 0.5, Fault: playback.FaultStatus}}}
+
+			replay := func() []playback.InjectedFault {
+				cassette, get := record(faults)
+				for i := 0; i < 10; i++ {
+					get()
+				}
+				return cassette.InjectedFaults()
+			}
+
+			injected := replay()
+			assert.NotEmpty(t, injected)
+			assert.True(t, len(injected) < 10)
+			assert.Equal(t, injected, replay())
+		})
+		t.Run("rewind injects same faults", func(t *testing.T) {
+			cassette, get := record(playback.Faults{Seed: 42, Rules: []playback.FaultRule{{Probability: 0.5, Fault: playback.FaultStatus}}})
+
+			replay := func() []playback.InjectedFault {
+				cassette.Rewind()
+				for i := 0; i < 10; i++ {
+					get()
+				}
+				return cassette.InjectedFaults()
+			}
+
+			injected := replay()
+			assert.NotEmpty(t, injected)
+			assert.Equal(t, injected, replay())
+		})
+		t.Run("doesn't log a truncate without a response", func(t *testing.T) {
+			p := playback.New().SetFaults(playback.Faults{Rules: []playback.FaultRule{{Fault: playback.FaultTruncate}}})
+			httpClient := &http.Client{Transport: p.HTTPTransport(http.DefaultTransport)}
+			cassette, _ := p.NewCassette()
+
+			get := func() error {
+				req, _ := http.NewRequest("GET", "http://127.0.0.1:1", nil)
+				req = req.WithContext(playback.NewContextWithCassette(req.Context(), cassette))
+				_, err := httpClient.Do(req)
+				return err
+			}
+
+			cassette.SetMode(playback.ModeRecord)
+			assert.NotNil(t, get())
+			cassette.SetMode(playback.ModePlayback)
+
+			assert.NotNil(t, get())
+			assert.True(t, cassette.IsPlaybackSucceeded())
+			assert.Empty(t, cassette.InjectedFaults())
+		})
+	})
+
+	t.Run("refresh stale records", func(t *testing.T) {
+		file := tempFile(t, playback.FileMask)
+		defer removeFilename(t, file.Name())
+
+		fresh := time.Now().UTC().Format(time.RFC3339)
+		fmt.Fprint(file, ""+
+			"- kind: result\n"+
+			"  key: stale\n"+
+			"  id: 1\n"+
+			"  responsemeta: int\n"+
+			"  response: |\n"+
+			"    1\n"+
+			"  created_at: 2000-01-01T00:00:00Z\n"+
+			"- kind: result\n"+
+			"  key: fresh\n"+
+			"  id: 2\n"+
+			"  responsemeta: int\n"+
+			"  response: |\n"+
+			"    1\n"+
+			"  created_at: "+fresh+"\n",
+		)
+		file.Close()
+
+		p := playback.New().SetMaxAge(time.Hour)
+		cassette, err := p.CassetteFromFile(file.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		cassette.SetMode(playback.ModeRefreshStale)
+
+		assert.Equal(t, 2, cassette.Result("stale", 2))
+		assert.Equal(t, 1, cassette.Result("fresh", 2))
+		assert.Nil(t, cassette.Finalize())
+
+		cassette, err = p.CassetteFromFile(file.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, 2, cassette.Result("stale", 0))
+		assert.Equal(t, 1, cassette.Result("fresh", 0))
+		assert.True(t, cassette.IsPlaybackSucceeded())
+
+		var dump struct {
+			Records []struct {
+				Key       string
+				ID        uint64
+				CreatedAt time.Time `yaml:"created_at"`
+			}
+		}
+		yaml.Unmarshal(cassette.MarshalToYAML(), &dump)
+		for _, rec := range dump.Records {
+			if rec.Key == "stale" {
+				assert.Equal(t, uint64(1), rec.ID)
+				assert.True(t, time.Since(rec.CreatedAt) < time.Hour)
+			}
+		}
+	})
+
+	t.Run("approval of new records", func(t *testing.T) {
+		newCassetteFile := func(t *testing.T) string {
+			file := tempFile(t, playback.FileMask)
+			fmt.Fprint(file, ""+
+				"- kind: result\n"+
+				"  key: known\n"+
+				"  id: 1\n"+
+				"  responsemeta: int\n"+
+				"  response: |\n"+
+				"    1\n",
+			)
+			file.Close()
+
+			return file.Name()
+		}
+		recordUnknown := func(t *testing.T, p *playback.Playback, filename string) {
+			cassette, err := p.CassetteFromFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cassette.SetMode(playback.ModePlaybackOrRecord)
+
+			assert.Equal(t, 1, cassette.Result("known", 0))
+			assert.Equal(t, 2, cassette.Result("unknown", 2))
+			assert.Nil(t, cassette.Finalize())
+		}
+
+		t.Run("new records are pending until approved", func(t *testing.T) {
+			filename := newCassetteFile(t)
+			defer removeFilename(t, filename)
+			defer removeFilename(t, playback.PendingFilename(filename))
+
+			p := playback.New().SetApproval(true)
+			recordUnknown(t, p, filename)
+
+			_, err := os.Stat(playback.PendingFilename(filename))
+			assert.Nil(t, err)
+
+			cassette, _ := p.CassetteFromFile(filename)
+			assert.Equal(t, 0, cassette.Result("unknown", 0))
+			assert.Len(t, cassette.PendingRecords(), 1)
+
+			assert.Nil(t, cassette.Approve())
+
+			_, err = os.Stat(playback.PendingFilename(filename))
+			assert.True(t, os.IsNotExist(err))
+
+			cassette, _ = p.CassetteFromFile(filename)
+			assert.Equal(t, 1, cassette.Result("known", 0))
+			assert.Equal(t, 2, cassette.Result("unknown", 0))
+			assert.True(t, cassette.IsPlaybackSucceeded())
+		})
+		t.Run("repeated calls keep a single pending record", func(t *testing.T) {
+			filename := newCassetteFile(t)
+			defer removeFilename(t, filename)
+			defer removeFilename(t, playback.PendingFilename(filename))
+
+			p := playback.New().SetApproval(true)
+			recordUnknown(t, p, filename)
+			recordUnknown(t, p, filename)
+
+			cassette, _ := p.CassetteFromFile(filename)
+			cassette.SetMode(playback.ModePlaybackOrRecord)
+			assert.Equal(t, 3, cassette.Result("unknown", 3))
+			assert.Equal(t, 3, cassette.Result("unknown", 3))
+			assert.Nil(t, cassette.Finalize())
+
+			cassette, _ = p.CassetteFromFile(filename)
+			assert.Equal(t, []playback.RecordRef{{ID: 2, Kind: playback.KindResult, Key: "unknown"}}, cassette.PendingRecords())
+			assert.Contains(t, string(cassette.MarshalPendingToYAML()), "    3\n")
+			assert.Equal(t, 1, cassette.Result("known", 0))
+			assert.True(t, cassette.IsPlaybackSucceeded())
+		})
+		t.Run("pending records can be rejected through the service", func(t *testing.T) {
+			filename := newCassetteFile(t)
+			defer removeFilename(t, filename)
+			defer removeFilename(t, playback.PendingFilename(filename))
+
+			p := playback.New().SetApproval(true)
+			recordUnknown(t, p, filename)
+
+			cassette, _ := p.CassetteFromFile(filename)
+			handler := p.NewPlaybackHTTPHandler()
+
+			req := httptest.NewRequest("GET", "http://example.com/playback/pending/?id="+cassette.ID, nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			assert.Contains(t, w.Body.String(), "key: unknown")
+
+			req = httptest.NewRequest("POST", "http://example.com/playback/reject/?id="+cassette.ID+"&record=2", nil)
+			w = httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Empty(t, cassette.PendingRecords())
+
+			_, err := os.Stat(playback.PendingFilename(filename))
+			assert.True(t, os.IsNotExist(err))
+		})
+		t.Run("new records land in the cassette without approval", func(t *testing.T) {
+			filename := newCassetteFile(t)
+			defer removeFilename(t, filename)
+
+			p := playback.New()
+			cassette, _ := p.CassetteFromFile(filename)
+			cassette.SetMode(playback.ModePlaybackOrRecord)
+			cassette.Result("unknown", 2)
+
+			assert.Empty(t, cassette.PendingRecords())
+			assert.Contains(t, string(cassette.MarshalToYAML()), "key: unknown")
+		})
+	})
+
+	t.Run("cassette file format", func(t *testing.T) {
+		legacy := "" +
+			"- kind: result\n" +
+			"  key: known\n" +
+			"  id: 1\n" +
+			"  responsemeta: int\n" +
+			"  response: |\n" +
+			"    1\n"
+
+		t.Run("headerless file is migrated on load", func(t *testing.T) {
+			cassette, err := playback.New().CassetteFromYAML([]byte(legacy))
+			assert.Nil(t, err)
+
+			assert.Equal(t, playback.FormatVersion, cassette.Header().Version)
+			assert.Equal(t, 1, cassette.Result("known", 0))
+			assert.True(t, cassette.IsPlaybackSucceeded())
+		})
+		t.Run("file of unknown version is rejected", func(t *testing.T) {
+			_, err := playback.New().CassetteFromYAML([]byte("version: 100\nrecords:\n" + legacy))
+			assert.NotNil(t, err)
+		})
+		t.Run("tags are kept", func(t *testing.T) {
+			cassette, _ := playback.New().NewCassette()
+			cassette.SetTags("checkout", "smoke")
+
+			loaded, err := playback.New().CassetteFromYAML(cassette.MarshalToYAML())
+			assert.Nil(t, err)
+			assert.Equal(t, []string{"checkout", "smoke"}, loaded.Tags())
+			assert.Equal(t, cassette.CreatedAt(), loaded.CreatedAt())
+		})
+		t.Run("tags are written to the file on finalize", func(t *testing.T) {
+			p := playback.New().WithFile().SetDefaultMode(playback.ModeRecord)
+			cassette, _ := p.NewCassette()
+			defer removeFilename(t, cassette.PathName())
+
+			cassette.Result("key", 1)
+			cassette.SetTags("checkout")
+			cassette.Finalize()
+
+			loaded, err := p.CassetteFromFile(cassette.PathName())
+			assert.Nil(t, err)
+			assert.Equal(t, []string{"checkout"}, loaded.Tags())
+			assert.Equal(t, 1, loaded.Result("key", 0))
+		})
+		t.Run("tags of a played back cassette aren't written", func(t *testing.T) {
+			p := playback.New().WithFile().SetDefaultMode(playback.ModeRecord)
+			cassette, _ := p.NewCassette()
+			defer removeFilename(t, cassette.PathName())
+			cassette.Result("key", 1)
+			cassette.Finalize()
+			dump, _ := ioutil.ReadFile(cassette.PathName())
+
+			loaded, err := p.CassetteFromFile(cassette.PathName())
+			assert.Nil(t, err)
+			loaded.SetTags("checkout")
+			assert.Equal(t, []string{"checkout"}, loaded.Tags())
+			loaded.Finalize()
+
+			written, _ := ioutil.ReadFile(cassette.PathName())
+			assert.Equal(t, string(dump), string(written))
+		})
+		t.Run("file is rewritten in the current version", func(t *testing.T) {
+			file := tempFile(t, playback.FileMask)
+			defer removeFilename(t, file.Name())
+			fmt.Fprint(file, legacy)
+			file.Close()
+
+			err := playback.MigrateFile(file.Name())
+			assert.Nil(t, err)
+
+			contents, _ := ioutil.ReadFile(file.Name())
+			assert.True(t, strings.HasPrefix(string(normalizeCreatedAt(contents)), cassetteHeader))
+
+			cassette, err := playback.New().CassetteFromFile(file.Name())
+			assert.Nil(t, err)
+			assert.Equal(t, 1, cassette.Result("known", 0))
+		})
+	})
+
+	t.Run("canonical serialization", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"id":1,"tags":["a","b"]}`)
+		}))
+		defer ts.Close()
+
+		record := func(p *playback.Playback) *playback.Cassette {
+			httpClient := &http.Client{Transport: p.HTTPTransport(http.DefaultTransport)}
+			cassette, _ := p.NewCassette()
+			cassette.SetMode(playback.ModeRecord)
+
+			for _, key := range []string{"c", "a", "b"} {
+				cassette.Result(key, key)
+			}
+
+			req, _ := http.NewRequest("GET", ts.URL+"/items", nil)
+			req = req.WithContext(playback.NewContextWithCassette(req.Context(), cassette))
+			res, _ := httpClient.Do(req)
+			ioutil.ReadAll(res.Body)
+			res.Body.Close()
+
+			cassette.SetMode(playback.ModePlayback)
+
+			return cassette
+		}
+
+		t.Run("records are written in id order", func(t *testing.T) {
+			cassette := record(playback.New())
+
+			var dump struct {
+				Records []struct{ ID uint64 }
+			}
+			yaml.Unmarshal(cassette.MarshalToYAML(), &dump)
+
+			ids := make([]uint64, 0, len(dump.Records))
+			for _, rec := range dump.Records {
+				ids = append(ids, rec.ID)
+			}
+			assert.Equal(t, []uint64{1, 2, 3, 4}, ids)
+			assert.Equal(t, string(cassette.MarshalToYAML()), string(cassette.MarshalToYAML()))
+		})
+		t.Run("http dumps are split and json bodies are pretty-printed", func(t *testing.T) {
+			cassette := record(playback.New().SetCanonical(true))
+			dump := string(cassette.MarshalToYAML())
+
+			assert.Contains(t, dump, "canonical: true\n")
+			assert.Contains(t, dump, "  request_http:\n    method: GET\n    url: /items\n    proto: HTTP/1.1\n")
+			assert.Contains(t, dump, "  response_http:\n    proto: HTTP/1.1\n    status: 200 OK\n")
+			assert.Contains(t, dump, "    - 'Content-Type: application/json'\n")
+			assert.Contains(t, dump, "    body: |\n      {\n        \"id\": 1,\n        \"tags\": [\n")
+		})
+		t.Run("canonical cassette is played back", func(t *testing.T) {
+			recorded := record(playback.New().SetCanonical(true))
+
+			p := playback.New()
+			cassette, err := p.CassetteFromYAML(recorded.MarshalToYAML())
+			assert.Nil(t, err)
+			assert.True(t, cassette.Canonical())
+			assert.Equal(t, string(recorded.MarshalToYAML()), string(cassette.MarshalToYAML()))
+
+			httpClient := &http.Client{Transport: p.HTTPTransport(http.DefaultTransport)}
+			req, _ := http.NewRequest("GET", ts.URL+"/items", nil)
+			req = req.WithContext(playback.NewContextWithCassette(req.Context(), cassette))
+			res, err := httpClient.Do(req)
+			assert.Nil(t, err)
+
+			body, _ := ioutil.ReadAll(res.Body)
+			assert.Equal(t, `{"id":1,"tags":["a","b"]}`, string(body))
+		})
+	})
+
+	t.Run("cassette formats", func(t *testing.T) {
+		for _, format := range []playback.Format{playback.FormatJSON, playback.FormatJSONL} {
+			t.Run(string(format), func(t *testing.T) {
+				t.Run("file is written and loaded", func(t *testing.T) {
+					p := playback.New().WithFile().SetFormat(format).SetDefaultMode(playback.ModeRecord)
+					cassette, _ := p.NewCassette()
+					defer removeFilename(t, cassette.PathName())
+
+					cassette.Result("a", 1)
+					cassette.Result("b", 2)
+					_, err := cassette.ResultWithError("err", func() (int, error) { return 0, io.EOF })
+					assert.Equal(t, io.EOF, err)
+					cassette.Finalize()
+
+					assert.True(t, strings.HasSuffix(cassette.PathName(), "."+string(format)))
+
+					loaded, err := p.CassetteFromFile(cassette.PathName())
+					assert.Nil(t, err)
+					assert.Equal(t, format, loaded.Format())
+					assert.Equal(t, 1, loaded.Result("a", 0))
+					assert.Equal(t, 2, loaded.Result("b", 0))
+					_, err = loaded.ResultWithError("err", func() (int, error) { return 1, nil })
+					assert.Equal(t, io.EOF.Error(), err.Error())
+					assert.True(t, loaded.IsPlaybackSucceeded())
+				})
+				t.Run("yaml cassette is converted", func(t *testing.T) {
+					recorded, _ := playback.New().CassetteFromYAML([]byte("" +
+						"- kind: result\n" +
+						"  key: panic\n" +
+						"  id: 1\n" +
+						"  panic:\n" +
+						"    reason: broken\n"))
+
+					cassette, err := playback.New().CassetteFromYAML(recorded.MarshalTo(format))
+					assert.Nil(t, err)
+					assert.Equal(t, format, cassette.Format())
+					assert.Equal(t, string(recorded.MarshalToYAML()), string(cassette.MarshalToYAML()))
+				})
+				t.Run("service returns the requested format", func(t *testing.T) {
+					p := playback.New()
+					cassette, _ := p.NewCassette()
+					cassette.SetMode(playback.ModeRecord)
+					cassette.Result("a", 1)
+
+					req := httptest.NewRequest("GET", "http://example.com/playback/get/?id="+cassette.ID+"&format="+string(format), nil)
+					w := httptest.NewRecorder()
+					p.NewPlaybackHTTPHandler().ServeHTTP(w, req)
+					body, _ := ioutil.ReadAll(w.Result().Body)
+
+					assert.Equal(t, string(cassette.MarshalTo(format)), string(body))
+
+					req = httptest.NewRequest("POST", "http://example.com/playback/add/", bytes.NewBuffer(body))
+					w = httptest.NewRecorder()
+					p.NewPlaybackHTTPHandler().ServeHTTP(w, req)
+					added, _ := ioutil.ReadAll(w.Result().Body)
+
+					assert.Equal(t, string(cassette.MarshalToYAML()), string(p.Get(string(added)).MarshalToYAML()))
+				})
+			})
+		}
+		t.Run("format is negotiated with the Accept header", func(t *testing.T) {
+			p := playback.New()
+			cassette, _ := p.NewCassette()
+
+			req := httptest.NewRequest("GET", "http://example.com/playback/get/?id="+cassette.ID, nil)
+			req.Header.Set("Accept", "application/json")
+			w := httptest.NewRecorder()
+			p.NewPlaybackHTTPHandler().ServeHTTP(w, req)
+
+			assert.Equal(t, "application/json", w.Result().Header.Get("Content-Type"))
+		})
+		t.Run("one-line jsonl dump stays jsonl", func(t *testing.T) {
+			empty, _ := playback.New().NewCassette()
+			headerOnly := empty.MarshalTo(playback.FormatJSONL)
+			assert.Equal(t, 1, bytes.Count(headerOnly, []byte("\n")))
+
+			cassette, err := playback.New().CassetteFromYAML(headerOnly)
+			assert.Nil(t, err)
+			assert.Equal(t, playback.FormatJSONL, cassette.Format())
+
+			cassette, err = playback.New().CassetteFromYAML([]byte(`{"kind":"result","key":"a","id":1,"responsemeta":"int","response":"1\n"}` + "\n"))
+			assert.Nil(t, err)
+			assert.Equal(t, playback.FormatJSONL, cassette.Format())
+			assert.Equal(t, 1, cassette.Result("a", 0))
+		})
+	})
+
+	t.Run("compressed cassettes", func(t *testing.T) {
+		record := func(t *testing.T, format playback.Format) *playback.Cassette {
+			p := playback.New().WithFile().SetCompressed(true).SetFormat(format).SetDefaultMode(playback.ModeRecord)
+			cassette, _ := p.NewCassette()
+
+			cassette.Result("a", 1)
+			cassette.Result("b", 2)
+			cassette.Finalize()
+
+			return cassette
+		}
+
+		for _, format := range []playback.Format{playback.FormatYAML, playback.FormatJSON, playback.FormatJSONL} {
+			t.Run(string(format)+" file is compressed and loaded", func(t *testing.T) {
+				cassette := record(t, format)
+				defer removeFilename(t, cassette.PathName())
+
+				assert.True(t, strings.HasSuffix(cassette.PathName(), ".gz"))
+
+				contents, _ := ioutil.ReadFile(cassette.PathName())
+				assert.True(t, bytes.HasPrefix(contents, []byte{0x1f, 0x8b}))
+
+				loaded, err := playback.New().CassetteFromFile(cassette.PathName())
+				assert.Nil(t, err)
+				assert.Equal(t, 1, loaded.Result("a", 0))
+				assert.Equal(t, 2, loaded.Result("b", 0))
+				assert.True(t, loaded.IsPlaybackSucceeded())
+			})
+		}
+		t.Run("records written before a broken write are loaded", func(t *testing.T) {
+			cassette := record(t, playback.FormatYAML)
+			defer removeFilename(t, cassette.PathName())
+
+			contents, _ := ioutil.ReadFile(cassette.PathName())
+			ioutil.WriteFile(cassette.PathName(), contents[:len(contents)-5], 0644)
+
+			loaded, err := playback.New().CassetteFromFile(cassette.PathName())
+			assert.Nil(t, err)
+			assert.Equal(t, 1, loaded.Result("a", 0))
+			assert.Equal(t, 0, loaded.Result("b", 0))
+		})
+		t.Run("pending file is compressed too", func(t *testing.T) {
+			assert.Equal(t, "cassette.pending.yml.gz", playback.PendingFilename("cassette.yml.gz"))
+		})
+	})
+
+	t.Run("cassette directories", func(t *testing.T) {
+		png := []byte{0x89, 'P', 'N', 'G', 0x0d, 0x0a, 0x1a, 0x0a, 0x00, 0xff}
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/image":
+				w.Header().Set("Content-Type", "image/png")
+				w.Write(png)
+			default:
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprint(w, `{"id":1}`)
+			}
+		}))
+		defer ts.Close()
+
+		get := func(p *playback.Playback, cassette *playback.Cassette, path string) ([]byte, error) {
+			httpClient := &http.Client{Transport: p.HTTPTransport(http.DefaultTransport)}
+			req, _ := http.NewRequest("GET", ts.URL+path, nil)
+			req = req.WithContext(playback.NewContextWithCassette(req.Context(), cassette))
+			res, err := httpClient.Do(req)
+			if err != nil {
+				return nil, err
+			}
+			defer res.Body.Close()
+
+			return ioutil.ReadAll(res.Body)
+		}
+		record := func(t *testing.T) *playback.Cassette {
+			p := playback.New().WithFile().SetLayout(playback.LayoutDir).SetDefaultMode(playback.ModeRecord)
+			cassette, _ := p.NewCassette()
+
+			for _, path := range []string{"/a", "/b", "/image"} {
+				get(p, cassette, path)
+			}
+			cassette.Finalize()
+
+			return cassette
+		}
+
+		t.Run("bodies are stored in content-addressed files", func(t *testing.T) {
+			cassette := record(t)
+			defer os.RemoveAll(cassette.PathName())
+
+			bodies, _ := filepath.Glob(filepath.Join(cassette.PathName(), "bodies", "*"))
+			assert.Len(t, bodies, 2)
+
+			exts := make([]string, 0, len(bodies))
+			for _, body := range bodies {
+				exts = append(exts, filepath.Ext(body))
+			}
+			assert.ElementsMatch(t, []string{".json", ".png"}, exts)
+
+			index, err := ioutil.ReadFile(filepath.Join(cassette.PathName(), "index.yml"))
+			assert.Nil(t, err)
+			assert.NotContains(t, string(index), `{"id":1}`)
+			assert.Contains(t, string(index), "response_body: bodies/")
+		})
+		t.Run("bodies are loaded on playback", func(t *testing.T) {
+			cassette := record(t)
+			defer os.RemoveAll(cassette.PathName())
+
+			p := playback.New()
+			loaded, err := p.CassetteFromFile(cassette.PathName())
+			assert.Nil(t, err)
+
+			body, err := get(p, loaded, "/a")
+			assert.Nil(t, err)
+			assert.Equal(t, `{"id":1}`, string(body))
+
+			images, _ := filepath.Glob(filepath.Join(cassette.PathName(), "bodies", "*.png"))
+			image, _ := ioutil.ReadFile(images[0])
+			os.Remove(images[0])
+
+			body, err = get(p, loaded, "/b")
+			assert.Nil(t, err)
+			assert.Equal(t, `{"id":1}`, string(body))
+
+			_, err = get(p, loaded, "/image")
+			assert.NotNil(t, err)
+
+			ioutil.WriteFile(images[0], image, 0644)
+			body, err = get(p, loaded, "/image")
+			assert.Nil(t, err)
+			assert.Equal(t, image, body)
+		})
+		t.Run("missing body doesn't fail marshaling", func(t *testing.T) {
+			cassette := record(t)
+			defer os.RemoveAll(cassette.PathName())
+
+			loaded, _ := playback.New().CassetteFromFile(cassette.PathName())
+			images, _ := filepath.Glob(filepath.Join(cassette.PathName(), "bodies", "*.png"))
+			os.Remove(images[0])
+
+			assert.NotEmpty(t, loaded.MarshalToYAML())
+			assert.Nil(t, loaded.Error())
+		})
+		t.Run("marshaled cassette has bodies inline", func(t *testing.T) {
+			cassette := record(t)
+			defer os.RemoveAll(cassette.PathName())
+
+			loaded, _ := playback.New().CassetteFromFile(cassette.PathName())
+			dump := string(loaded.MarshalToYAML())
+
+			assert.Contains(t, dump, `{\"id\":1}`)
+			assert.NotContains(t, dump, "response_body")
+		})
+	})
+
+	t.Run("HAR", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"path":"`+r.URL.Path+`"}`)
+		}))
+		defer ts.Close()
+
+		get := func(p *playback.Playback, cassette *playback.Cassette, path string) (string, error) {
+			httpClient := &http.Client{Transport: p.HTTPTransport(http.DefaultTransport)}
+			req, _ := http.NewRequest("GET", ts.URL+path, nil)
+			req = req.WithContext(playback.NewContextWithCassette(req.Context(), cassette))
+			res, err := httpClient.Do(req)
+			if err != nil {
+				return "", err
+			}
+			defer res.Body.Close()
+
+			body, _ := ioutil.ReadAll(res.Body)
+			return string(body), nil
+		}
+
+		t.Run("cassette is exported and imported back", func(t *testing.T) {
+			p := playback.New()
+			recorded, _ := p.NewCassette()
+			recorded.SetMode(playback.ModeRecord)
+			get(p, recorded, "/a")
+			get(p, recorded, "/b")
+
+			har, err := recorded.MarshalToHAR()
+			assert.Nil(t, err)
+
+			var parsed playback.HAR
+			assert.Nil(t, json.Unmarshal(har, &parsed))
+			assert.Equal(t, "1.2", parsed.Log.Version)
+			assert.Len(t, parsed.Log.Entries, 2)
+			assert.Equal(t, "GET", parsed.Log.Entries[0].Request.Method)
+			assert.Equal(t, ts.URL+"/a", parsed.Log.Entries[0].Request.URL)
+			assert.Equal(t, 200, parsed.Log.Entries[0].Response.Status)
+			assert.Equal(t, `{"path":"/a"}`, parsed.Log.Entries[0].Response.Content.Text)
+
+			cassette, err := p.CassetteFromHAR(har, "")
+			assert.Nil(t, err)
+
+			body, err := get(p, cassette, "/a")
+			assert.Nil(t, err)
+			assert.Equal(t, `{"path":"/a"}`, body)
+			body, err = get(p, cassette, "/b")
+			assert.Nil(t, err)
+			assert.Equal(t, `{"path":"/b"}`, body)
+			assert.True(t, cassette.IsPlaybackSucceeded())
+		})
+
+		har := []byte(`{"log": {"version": "1.2", "creator": {"name": "browser", "version": "1"}, "entries": [{
+			"startedDateTime": "2020-01-02T03:04:05.678Z",
+			"time": 12.5,
+			"request": {"method": "GET", "url": "` + ts.URL + `/image", "httpVersion": "HTTP/2.0",
+				"headers": [{"name": ":authority", "value": "example.com"}], "queryString": [], "cookies": [],
+				"headersSize": -1, "bodySize": 0},
+			"response": {"status": 200, "statusText": "", "httpVersion": "HTTP/2.0",
+				"headers": [{"name": "content-type", "value": "image/png"}, {"name": "content-encoding", "value": "br"}],
+				"cookies": [], "content": {"size": 3, "mimeType": "image/png", "text": "AAEC", "encoding": "base64"},
+				"redirectURL": "", "headersSize": -1, "bodySize": -1},
+			"cache": {}, "timings": {"send": 0, "wait": 12.5, "receive": 0}
+		}]}}`)
+
+		t.Run("browser HAR is replayed by the transport", func(t *testing.T) {
+			p := playback.New()
+			cassette, err := p.CassetteFromHAR(har, playback.KindHTTP)
+			assert.Nil(t, err)
+
+			body, err := get(p, cassette, "/image")
+			assert.Nil(t, err)
+			assert.Equal(t, string([]byte{0, 1, 2}), body)
+		})
+		t.Run("HAR entry is imported as an incoming request", func(t *testing.T) {
+			cassette, err := playback.New().CassetteFromHAR(har, playback.KindHTTPRequest)
+			assert.Nil(t, err)
+
+			req, err := cassette.HTTPRequest()
+			assert.Nil(t, err)
+			assert.Equal(t, "/image", req.URL.Path)
+
+			res, err := cassette.HTTPResponse(req)
+			assert.Nil(t, err)
+			assert.Equal(t, "image/png", res.Header.Get("Content-Type"))
+		})
+		t.Run("other kinds are rejected", func(t *testing.T) {
+			_, err := playback.New().CassetteFromHAR(har, playback.KindResult)
+			assert.NotNil(t, err)
+		})
+	})
+
+	t.Run("go-vcr and WireMock", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "real "+r.URL.Path)
+		}))
+		defer ts.Close()
+
+		do := func(p *playback.Playback, cassette *playback.Cassette, req *http.Request) (*http.Response, string, error) {
+			httpClient := &http.Client{Transport: p.HTTPTransport(http.DefaultTransport)}
+			req = req.WithContext(playback.NewContextWithCassette(req.Context(), cassette))
+			res, err := httpClient.Do(req)
+			if err != nil {
+				return nil, "", err
+			}
+			defer res.Body.Close()
+
+			body, _ := ioutil.ReadAll(res.Body)
+			return res, string(body), nil
+		}
+		get := func(p *playback.Playback, cassette *playback.Cassette, url string) (string, error) {
+			req, _ := http.NewRequest("GET", url, nil)
+			_, body, err := do(p, cassette, req)
+			return body, err
+		}
+
+		t.Run("go-vcr interactions are replayed by method and url", func(t *testing.T) {
+			vcr := "" +
+				"version: 1\n" +
+				"interactions:\n" +
+				"- request:\n" +
+				"    body: \"\"\n" +
+				"    form: {}\n" +
+				"    headers:\n" +
+				"      X-Token: [secret]\n" +
+				"    url: " + ts.URL + "/users?id=1\n" +
+				"    method: GET\n" +
+				"  response:\n" +
+				"    body: '{\"name\":\"alice\"}'\n" +
+				"    headers:\n" +
+				"      Content-Type: [application/json]\n" +
+				"    status: 200 OK\n" +
+				"    code: 200\n" +
+				"    duration: 15ms\n"
+
+			p := playback.New()
+			cassette, err := p.CassetteFromGoVCR([]byte(vcr))
+			assert.Nil(t, err)
+
+			body, err := get(p, cassette, ts.URL+"/users?id=1")
+			assert.Nil(t, err)
+			assert.Equal(t, `{"name":"alice"}`, body)
+			assert.True(t, cassette.IsPlaybackSucceeded())
+
+			_, err = get(p, cassette, ts.URL+"/users?id=2")
+			assert.NotNil(t, err)
+
+			cassette, _ = p.CassetteFromGoVCR([]byte(vcr))
+			_, err = get(p, cassette, strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)+"/users?id=1")
+			assert.NotNil(t, err)
+		})
+
+		mappings := `{"mappings": [
+			{"name": "any user", "priority": 5,
+				"request": {"method": "GET", "urlPathPattern": "/users/[0-9]+"},
+				"response": {"status": 200, "jsonBody": {"name": "anyone"}}},
+			{"name": "admin", "priority": 1,
+				"request": {"method": "GET", "urlPath": "/users/1", "headers": {"Authorization": {"matches": "Bearer .+"}}},
+				"response": {"status": 200, "body": "admin", "headers": {"X-Role": "admin"}}},
+			{"name": "create",
+				"request": {"method": "POST", "url": "/users", "bodyPatterns": [{"equalToJson": {"name": "bob"}}]},
+				"response": {"status": 201, "statusMessage": "Created"}}
+		]}`
+
+		t.Run("WireMock matchers are applied", func(t *testing.T) {
+			p := playback.New()
+			cassette, err := p.CassetteFromWireMock([]byte(mappings))
+			assert.Nil(t, err)
+
+			body, err := get(p, cassette, ts.URL+"/users/2")
+			assert.Nil(t, err)
+			assert.Equal(t, `{"name": "anyone"}`, body)
+
+			body, err = get(p, cassette, ts.URL+"/users/2")
+			assert.Nil(t, err)
+			assert.Equal(t, `{"name": "anyone"}`, body)
+
+			req, _ := http.NewRequest("GET", ts.URL+"/users/1", nil)
+			req.Header.Set("Authorization", "Bearer token")
+			res, body, err := do(p, cassette, req)
+			assert.Nil(t, err)
+			assert.Equal(t, "admin", body)
+			assert.Equal(t, "admin", res.Header.Get("X-Role"))
+
+			req, _ = http.NewRequest("POST", ts.URL+"/users", strings.NewReader(`{ "name" : "bob" }`))
+			res, _, err = do(p, cassette, req)
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusCreated, res.StatusCode)
+
+			req, _ = http.NewRequest("POST", ts.URL+"/users", strings.NewReader(`{"name":"eve"}`))
+			_, _, err = do(p, cassette, req)
+			assert.NotNil(t, err)
+		})
+		t.Run("recorded cassette is exported to WireMock", func(t *testing.T) {
+			p := playback.New()
+			recorded, _ := p.NewCassette()
+			recorded.SetMode(playback.ModeRecord)
+			get(p, recorded, ts.URL+"/a?x=1")
+
+			dump, err := recorded.MarshalToWireMock()
+			assert.Nil(t, err)
+
+			var exported playback.WireMockMappings
+			assert.Nil(t, json.Unmarshal(dump, &exported))
+			assert.Len(t, exported.Mappings, 1)
+			assert.Equal(t, "GET", exported.Mappings[0].Request.Method)
+			assert.Equal(t, "/a?x=1", exported.Mappings[0].Request.URL)
+			assert.Equal(t, "real /a", exported.Mappings[0].Response.Body)
+
+			cassette, err := p.CassetteFromWireMock(dump)
+			assert.Nil(t, err)
+
+			body, err := get(p, cassette, "http://other.example.com/a?x=1")
+			assert.Nil(t, err)
+			assert.Equal(t, "real /a", body)
+		})
+	})
+
+	t.Run("Pact", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"id":"`+r.URL.Path[1:]+`","items":[{"created_at":"2020-01-02"}]}`)
+		}))
+		defer ts.Close()
+		host := strings.TrimPrefix(ts.URL, "http://")
+
+		p := playback.New()
+		cassette, _ := p.NewCassette()
+		cassette.SetMode(playback.ModeRecord)
+		httpClient := &http.Client{Transport: p.HTTPTransport(http.DefaultTransport)}
+		for _, path := range []string{"/a", "/a", "/b?x=1"} {
+			req, _ := http.NewRequest("GET", ts.URL+path, nil)
+			req = req.WithContext(playback.NewContextWithCassette(req.Context(), cassette))
+			res, err := httpClient.Do(req)
+			assert.Nil(t, err)
+			res.Body.Close()
+		}
+
+		t.Run("interactions are grouped by provider host", func(t *testing.T) {
+			pacts, err := cassette.MarshalToPact(playback.PactOptions{
+				Consumer:  "checkout",
+				Providers: map[string]string{host: "users"},
+			})
+			assert.Nil(t, err)
+			assert.Len(t, pacts, 1)
+
+			var pact playback.Pact
+			assert.Nil(t, json.Unmarshal(pacts["users"], &pact))
+			assert.Equal(t, "checkout", pact.Consumer.Name)
+			assert.Equal(t, "users", pact.Provider.Name)
+			assert.Equal(t, "3.0.0", pact.Metadata.PactSpecification.Version)
+			assert.Len(t, pact.Interactions, 3)
+
+			assert.Equal(t, "GET /a", pact.Interactions[0].Description)
+			assert.Equal(t, "GET /a #2", pact.Interactions[1].Description)
+			interaction := pact.Interactions[2]
+			assert.Equal(t, "GET", interaction.Request.Method)
+			assert.Equal(t, "/b", interaction.Request.Path)
+			assert.Equal(t, map[string][]string{"x": {"1"}}, interaction.Request.Query)
+			assert.Empty(t, interaction.Request.Headers)
+			assert.Equal(t, 200, interaction.Response.Status)
+			assert.Equal(t, "application/json", interaction.Response.Headers["Content-Type"])
+			assert.NotContains(t, interaction.Response.Headers, "Date")
+			assert.Equal(t, "b", interaction.Response.Body.(map[string]interface{})["id"])
+			assert.Empty(t, interaction.Response.MatchingRules)
+		})
+		t.Run("volatile fields become type matchers", func(t *testing.T) {
+			pacts, err := cassette.Pacts(playback.PactOptions{
+				Volatile:        []string{"$.id", "$.items[*].created_at", "$.missing"},
+				VolatileHeaders: []string{"content-type"},
+			})
+			assert.Nil(t, err)
+
+			pact := pacts[host]
+			assert.Equal(t, "playback", pact.Consumer.Name)
+
+			rules := pact.Interactions[0].Response.MatchingRules
+			typeMatchers := playback.PactMatchers{Matchers: []playback.PactMatcher{{Match: "type"}}}
+			assert.Equal(t, map[string]playback.PactMatchers{
+				"$.id":                  typeMatchers,
+				"$.items[*].created_at": typeMatchers,
+			}, rules["body"])
+			assert.Equal(t, map[string]playback.PactMatchers{"Content-Type": typeMatchers}, rules["header"])
+			assert.Empty(t, pact.Interactions[0].Request.MatchingRules)
+		})
+	})
+
+	t.Run("OpenAPI validation", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/v1/users/1", "/v1/users/me":
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprint(w, `{"id":1,"name":"a","friends":[{"id":2,"name":"b"}]}`)
+			case "/v1/users/3":
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprint(w, `{"id":"3","extra":true}`)
+			case "/v1/users/5":
+				w.WriteHeader(http.StatusInternalServerError)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer ts.Close()
+
+		spec, err := playback.ParseOpenAPI([]byte(openAPIDocument))
+		assert.Nil(t, err)
+
+		call := func(p *playback.Playback, cassette *playback.Cassette, method, path string) {
+			httpClient := &http.Client{Transport: p.HTTPTransport(http.DefaultTransport)}
+			req, _ := http.NewRequest(method, ts.URL+path, nil)
+			req = req.WithContext(playback.NewContextWithCassette(req.Context(), cassette))
+			res, err := httpClient.Do(req)
+			assert.Nil(t, err)
+			res.Body.Close()
+		}
+		messages := func(violations []playback.OpenAPIViolation) []string {
+			var messages []string
+			for _, violation := range violations {
+				messages = append(messages, violation.Message)
+			}
+			return messages
+		}
+
+		t.Run("cassette is validated against the document", func(t *testing.T) {
+			p := playback.New()
+			cassette, _ := p.NewCassette()
+			cassette.SetMode(playback.ModeRecord)
+			call(p, cassette, "GET", "/v1/users/1?verbose=true")
+			call(p, cassette, "GET", "/v1/users/me")
+
+			assert.Empty(t, cassette.ValidateOpenAPI(spec))
+
+			call(p, cassette, "GET", "/v1/users/abc?verbose=maybe")
+			call(p, cassette, "GET", "/v1/users/3")
+			call(p, cassette, "GET", "/v1/users/5")
+			call(p, cassette, "POST", "/v1/users/1")
+			call(p, cassette, "GET", "/v1/unknown")
+
+			violations := cassette.ValidateOpenAPI(spec)
+			assert.Equal(t, []string{
+				"query parameter verbose: expected boolean, got string",
+				"path parameter id: expected integer, got string",
+				"response body $: property name is required",
+				"response body $.extra: property is not allowed",
+				"response body $.id: expected integer, got string",
+				"status 500 is not documented",
+				"method POST is not documented for /users/{id}",
+				"path /v1/unknown is not documented",
+			}, messages(violations))
+			assert.Equal(t, uint64(3), violations[0].ID)
+			assert.Equal(t, uint64(4), violations[2].ID)
+		})
+		t.Run("recorded responses are validated when opted in", func(t *testing.T) {
+			p := playback.New().SetOpenAPI(spec)
+			cassette, _ := p.NewCassette()
+			cassette.SetMode(playback.ModeRecord)
+			call(p, cassette, "GET", "/v1/users/1")
+			assert.Empty(t, cassette.OpenAPIViolations())
+
+			call(p, cassette, "GET", "/v1/users/5")
+			assert.Equal(t, []string{"status 500 is not documented"}, messages(cassette.OpenAPIViolations()))
+
+			cassette.SetOpenAPI(nil)
+			call(p, cassette, "GET", "/v1/unknown")
+			assert.Len(t, cassette.OpenAPIViolations(), 1)
+		})
+		t.Run("other versions are rejected", func(t *testing.T) {
+			_, err := playback.ParseOpenAPI([]byte(`swagger: "2.0"`))
+			assert.NotNil(t, err)
+		})
+	})
+
+	t.Run("OpenAPI synthesized responses", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"id":1,"name":"real"}`)
+		}))
+		defer ts.Close()
+
+		spec, _ := playback.ParseOpenAPI([]byte(openAPIDocument))
+
+		get := func(p *playback.Playback, cassette *playback.Cassette, path string) (string, error) {
+			httpClient := &http.Client{Transport: p.HTTPTransport(http.DefaultTransport)}
+			req, _ := http.NewRequest("GET", ts.URL+path, nil)
+			req = req.WithContext(playback.NewContextWithCassette(req.Context(), cassette))
+			res, err := httpClient.Do(req)
+			if err != nil {
+				return "", err
+			}
+			defer res.Body.Close()
+
+			body, _ := ioutil.ReadAll(res.Body)
+			return string(body), nil
+		}
+
+		p := playback.New()
+		cassette, _ := p.NewCassette()
+		cassette.SetMode(playback.ModePlayback)
+		cassette.SetMissPolicy(playback.KindHTTP, playback.MissPolicy{Action: playback.MissSynthesize, OpenAPI: spec})
+
+		t.Run("misses are answered from examples and schemas", func(t *testing.T) {
+			body, err := get(p, cassette, "/v1/users/me")
+			assert.Nil(t, err)
+			assert.Equal(t, `{"id":7,"name":"me"}`, body)
+
+			body, err = get(p, cassette, "/v1/users/1")
+			assert.Nil(t, err)
+			assert.Equal(t, `{"friends":[],"id":42,"name":"string"}`, body)
+			assert.Nil(t, cassette.Error())
+
+			_, err = get(p, cassette, "/v1/unknown")
+			assert.NotNil(t, err)
+
+			assert.Len(t, cassette.GeneratedRecords(), 2)
+			assert.Contains(t, string(cassette.MarshalToYAML()), "generated: true")
+			assert.Empty(t, cassette.ValidateOpenAPI(spec))
+		})
+		t.Run("generated records are replaced by real recordings", func(t *testing.T) {
+			loaded, err := p.CassetteFromYAML(cassette.MarshalToYAML())
+			assert.Nil(t, err)
+			loaded.SetMode(playback.ModePlayback)
+
+			body, err := get(p, loaded, "/v1/users/me")
+			assert.Nil(t, err)
+			assert.Equal(t, `{"id":7,"name":"me"}`, body)
+
+			loaded.Rewind()
+			loaded.SetMode(playback.ModePlaybackOrRecord)
+			generated := loaded.GeneratedRecords()
+
+			body, err = get(p, loaded, "/v1/users/me")
+			assert.Nil(t, err)
+			assert.Equal(t, `{"id":1,"name":"real"}`, body)
+
+			assert.Equal(t, generated[1:], loaded.GeneratedRecords())
+			assert.Contains(t, string(loaded.MarshalToYAML()), `{\"id\":1,\"name\":\"real\"}`)
+
+			body, err = get(p, loaded, "/v1/users/me")
+			assert.Nil(t, err)
+			assert.Equal(t, `{"id":1,"name":"real"}`, body)
+		})
+	})
+
+	t.Run("stores", func(t *testing.T) {
+		testStore := func(t *testing.T, store playback.Store) {
+			_, err := store.Load("a.yml")
+			assert.Equal(t, playback.ErrStoreNotFound, err)
+
+			assert.Nil(t, store.Save("a.yml", []byte("a")))
+			assert.Nil(t, store.Save("b/c.yml", []byte("c")))
+			assert.Nil(t, store.Save("a.yml", []byte("aa")))
+
+			dump, err := store.Load("a.yml")
+			assert.Nil(t, err)
+			assert.Equal(t, "aa", string(dump))
+
+			names, err := store.List()
+			assert.Nil(t, err)
+			assert.Equal(t, []string{"a.yml", "b/c.yml"}, names)
+
+			assert.Nil(t, store.Delete("a.yml"))
+			assert.Equal(t, playback.ErrStoreNotFound, store.Delete("a.yml"))
+			names, _ = store.List()
+			assert.Equal(t, []string{"b/c.yml"}, names)
+		}
+
+		t.Run("memory store", func(t *testing.T) {
+			testStore(t, playback.NewMemoryStore())
+		})
+		t.Run("directory store", func(t *testing.T) {
+			root, _ := ioutil.TempDir("", "playback-store")
+			defer os.RemoveAll(root)
+
+			testStore(t, playback.NewDirStore(root))
+
+			store := playback.NewDirStore(filepath.Join(root, "inner"))
+			assert.Nil(t, store.Save("../../escape.yml", []byte("x")))
+			_, err := os.Stat(filepath.Join(root, "inner", "escape.yml"))
+			assert.Nil(t, err)
+
+			outside, _ := ioutil.TempDir("", "playback-outside")
+			defer os.RemoveAll(outside)
+			assert.Nil(t, os.Symlink(outside, filepath.Join(root, "inner", "link")))
+
+			assert.Equal(t, playback.ErrOutsideCassetteRoot, store.Save("link/planted.yml", []byte("x")))
+			_, err = store.Load("link/planted.yml")
+			assert.Equal(t, playback.ErrOutsideCassetteRoot, err)
+			_, err = os.Stat(filepath.Join(outside, "planted.yml"))
+			assert.True(t, os.IsNotExist(err))
+		})
+		t.Run("bbolt store", func(t *testing.T) {
+			root, _ := ioutil.TempDir("", "playback-store")
+			defer os.RemoveAll(root)
+
+			store, err := boltstore.Open(filepath.Join(root, "cassettes.db"))
+			assert.Nil(t, err)
+			defer store.Close()
+
+			testStore(t, store)
+		})
+
+		t.Run("cassette is saved to and loaded from a store", func(t *testing.T) {
+			p := playback.New().SetStore(playback.PathTypeMemory, playback.NewMemoryStore())
+			cassette, _ := p.NewCassette()
+			cassette.SetMode(playback.ModeRecord)
+			_, err := cassette.WithStore(playback.PathTypeMemory, "")
+			assert.Nil(t, err)
+			cassette.Result("rand.Intn", 10)
+			assert.Nil(t, cassette.Sync())
+
+			assert.Equal(t, playback.PathTypeMemory, cassette.PathType())
+			assert.Equal(t, "playback."+cassette.ID+".yml", cassette.PathName())
+
+			loaded, err := p.CassetteFromStore(playback.PathTypeMemory, cassette.PathName())
+			assert.Nil(t, err)
+			loaded.SetMode(playback.ModePlayback)
+			assert.Equal(t, 10, loaded.Result("rand.Intn", 0))
+
+			_, err = p.CassetteFromStore("unknown", cassette.PathName())
+			assert.NotNil(t, err)
+		})
+		t.Run("no store is registered by default", func(t *testing.T) {
+			p := playback.New()
+			assert.Nil(t, p.Store(playback.PathTypeMemory))
+
+			cassette, _ := p.NewCassette()
+			_, err := cassette.WithStore(playback.PathTypeMemory, "")
+			assert.NotNil(t, err)
+		})
+		t.Run("cassette is saved to a store on sync", func(t *testing.T) {
+			store := &countingStore{Store: playback.NewMemoryStore()}
+			p := playback.New().SetStore(playback.PathTypeDir, store)
+			cassette, _ := p.NewCassette()
+			cassette.SetMode(playback.ModeRecord)
+			cassette.WithStore(playback.PathTypeDir, "a.yml")
+
+			for i := 0; i < 10; i++ {
+				cassette.Result("rand.Intn", i)
+			}
+			assert.Equal(t, 0, store.saves)
+
+			assert.Nil(t, cassette.Sync())
+			assert.Nil(t, cassette.Finalize())
+			assert.Equal(t, 1, store.saves)
+		})
+		t.Run("middleware selects the store by path type", func(t *testing.T) {
+			store := playback.NewMemoryStore()
+			p := playback.New().SetStore(playback.PathTypeDir, store)
+			result := 1
+			handler := p.NewHTTPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, playback.CassetteFromContext(r.Context()).Result("result", result))
+			}))
+			serve := func(mode playback.Mode) *http.Response {
+				req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+				req.Header.Set(playback.HeaderMode, string(mode))
+				req.Header.Set(playback.HeaderCassettePathType, string(playback.PathTypeDir))
+				req.Header.Set(playback.HeaderCassettePathName, "scenarios/foo.yml")
+
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, req)
+				return w.Result()
+			}
+
+			res := serve(playback.ModeRecord)
+			assert.Equal(t, string(playback.PathTypeDir), res.Header.Get(playback.HeaderCassettePathType))
+			assert.Equal(t, "scenarios/foo.yml", res.Header.Get(playback.HeaderCassettePathName))
+			names, _ := store.List()
+			assert.Equal(t, []string{"scenarios/foo.yml"}, names)
+
+			result = 2
+			res = serve(playback.ModePlayback)
+			body, _ := ioutil.ReadAll(res.Body)
+			assert.Equal(t, "1", string(body))
+			assert.Equal(t, "true", res.Header.Get(playback.HeaderSuccess))
+		})
+	})
+
+	t.Run("file system cassettes", func(t *testing.T) {
+		p := playback.New()
+		recorded, _ := p.NewCassette()
+		recorded.SetMode(playback.ModeRecord)
+		recorded.Result("result", 1)
+
+		fsys := fstest.MapFS{
+			"cassettes/a.yml": &fstest.MapFile{Data: recorded.MarshalToYAML()},
+		}
+
+		t.Run("cassette is loaded from a file system", func(t *testing.T) {
+			cassette, err := p.CassetteFromFS(fsys, "cassettes/a.yml")
+			assert.Nil(t, err)
+			assert.Equal(t, playback.PathTypeFS, cassette.PathType())
+
+			cassette.SetMode(playback.ModePlayback)
+			assert.Equal(t, 1, cassette.Result("result", 2))
+
+			_, err = p.CassetteFromFS(fsys, "cassettes/missing.yml")
+			assert.NotNil(t, err)
+		})
+		t.Run("cassette directory is loaded from a file system", func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprint(w, `{"id":1}`)
+			}))
+			defer ts.Close()
+
+			p := playback.New().WithFile().SetLayout(playback.LayoutDir).SetDefaultMode(playback.ModeRecord)
+			recorded, _ := p.NewCassette()
+			defer os.RemoveAll(recorded.PathName())
+
+			httpClient := &http.Client{Transport: p.HTTPTransport(http.DefaultTransport)}
+			req, _ := http.NewRequest("GET", ts.URL, nil)
+			req = req.WithContext(playback.NewContextWithCassette(req.Context(), recorded))
+			res, _ := httpClient.Do(req)
+			res.Body.Close()
+			recorded.Finalize()
+
+			fsys := os.DirFS(filepath.Dir(recorded.PathName()))
+			name := filepath.Base(recorded.PathName())
+			cassette, err := p.CassetteFromFS(fsys, name)
+			assert.Nil(t, err)
+			cassette.SetMode(playback.ModePlayback)
+
+			req = req.WithContext(playback.NewContextWithCassette(context.Background(), cassette))
+			res, err = httpClient.Do(req)
+			assert.Nil(t, err)
+			body, _ := ioutil.ReadAll(res.Body)
+			assert.Equal(t, `{"id":1}`, string(body))
+
+			names, err := playback.NewFSStore(fsys).List()
+			assert.Nil(t, err)
+			assert.Contains(t, names, name)
+		})
+		t.Run("middleware resolves cassette names through the file system", func(t *testing.T) {
+			p := playback.New().SetStore(playback.PathTypeFS, playback.NewFSStore(fsys))
+			handler := p.NewHTTPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, playback.CassetteFromContext(r.Context()).Result("result", 2))
+			}))
+
+			req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+			req.Header.Set(playback.HeaderMode, string(playback.ModePlayback))
+			req.Header.Set(playback.HeaderCassettePathType, string(playback.PathTypeFS))
+			req.Header.Set(playback.HeaderCassettePathName, "cassettes/a.yml")
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			body, _ := ioutil.ReadAll(w.Result().Body)
+			assert.Equal(t, "1", string(body))
+		})
+		t.Run("file system store is read-only", func(t *testing.T) {
+			store := playback.NewFSStore(fsys)
+			assert.NotNil(t, store.Save("cassettes/b.yml", nil))
+			assert.NotNil(t, store.Delete("cassettes/a.yml"))
+
+			_, err := store.Load("../a.yml")
+			assert.NotNil(t, err)
+
+			p := playback.New().SetStore(playback.PathTypeFS, store)
+			cassette, _ := p.NewCassette()
+			_, err = cassette.WithStore(playback.PathTypeFS, "cassettes/b.yml")
+			assert.NotNil(t, err)
+		})
+	})
+
+	t.Run("cassette library", func(t *testing.T) {
+		p := playback.New()
+		recorded, _ := p.NewCassette()
+		recorded.SetMode(playback.ModeRecord)
+		recorded.Result("result", 1)
+		other, _ := p.NewCassette()
+		other.SetMode(playback.ModeRecord)
+		other.Result("result", 2)
 
-		t.Run("func", func(t *testing.T) {
-			t.Run("success", func(t *testing.T) {
-				p := playback.New().WithFile().SetDefaultMode(playback.ModeRecord)
-				cassette, _ := p.NewCassette()
-				defer removeFilename(t, cassette.PathName())
+		store := playback.NewMemoryStore()
+		store.Save("checkout.yml", recorded.MarshalToYAML())
+		store.Save("refund.json", other.MarshalTo(playback.FormatJSON))
 
-				key := "rand.Intn"
-				f := func() (interface{}, error) { return rand.Intn(randRange), nil }
+		t.Run("scenarios are named after cassettes", func(t *testing.T) {
+			p := playback.New()
+			assert.Nil(t, p.SetLibrary(store))
+			assert.Equal(t, []string{"checkout", "refund"}, p.Library())
 
-				numberExpected, err := cassette.ResultWithError(key, f)
+			cassette, err := p.CassetteByName("refund")
+			assert.Nil(t, err)
+			assert.Equal(t, "refund", cassette.Name())
+			assert.Equal(t, 2, cassette.Result("result", 3))
 
-				assert.Nil(t, err)
-				assert.NotEmpty(t, numberExpected)
+			_, err = p.CassetteByName("missing")
+			assert.NotNil(t, err)
+		})
+		t.Run("invalid cassettes keep the library unchanged", func(t *testing.T) {
+			p := playback.New()
+			assert.Nil(t, p.SetLibrary(store))
 
-				cassette, _ = p.CassetteFromFile(cassette.PathName())
+			broken := playback.NewMemoryStore()
+			broken.Save("broken.yml", []byte("records: ["))
+			assert.NotNil(t, p.SetLibrary(broken))
+			assert.Equal(t, []string{"checkout", "refund"}, p.Library())
+		})
+		t.Run("cassette directories of a directory store are scenarios", func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprint(w, `{"id":1}`)
+			}))
+			defer ts.Close()
 
-				numberGot, err := cassette.ResultWithError(key, f)
+			root, _ := ioutil.TempDir("", "playback-library")
+			defer os.RemoveAll(root)
 
-				assert.Nil(t, err)
-				assert.Equal(t, numberExpected, numberGot)
-				assert.True(t, cassette.IsPlaybackSucceeded())
-			})
-			t.Run("with error", func(t *testing.T) {
-				p := playback.New().WithFile().SetDefaultMode(playback.ModeRecord)
-				cassette, _ := p.NewCassette()
-				defer removeFilename(t, cassette.PathName())
+			p := playback.New().WithFile().SetLayout(playback.LayoutDir).SetDefaultMode(playback.ModeRecord)
+			httpClient := &http.Client{Transport: p.HTTPTransport(http.DefaultTransport)}
+			get := func(cassette *playback.Cassette) string {
+				req, _ := http.NewRequest("GET", ts.URL, nil)
+				req = req.WithContext(playback.NewContextWithCassette(req.Context(), cassette))
+				res, err := httpClient.Do(req)
+				if err != nil {
+					return err.Error()
+				}
+				defer res.Body.Close()
 
-				key := "rand.Intn"
-				f := func() (interface{}, error) { return 0, fmt.Errorf("Failed") }
+				body, _ := ioutil.ReadAll(res.Body)
+				return string(body)
+			}
 
-				numberExpected, errExpected := cassette.ResultWithError(key, f)
+			cassette, _ := p.NewCassette()
+			get(cassette)
+			cassette.Finalize()
+			assert.Nil(t, os.Rename(cassette.PathName(), filepath.Join(root, "checkout")))
 
-				assert.Error(t, errExpected)
-				assert.Empty(t, numberExpected)
+			p = playback.New()
+			assert.Nil(t, p.SetLibrary(playback.NewDirStore(root)))
+			assert.Equal(t, []string{"checkout"}, p.Library())
 
-				cassette, _ = p.CassetteFromFile(cassette.PathName())
+			cassette, err := p.CassetteByName("checkout")
+			assert.Nil(t, err)
+			assert.Equal(t, `{"id":1}`, get(cassette))
+			assert.True(t, cassette.IsPlaybackSucceeded())
+		})
+		t.Run("middleware selects scenario by name", func(t *testing.T) {
+			p := playback.New()
+			p.SetLibrary(store)
+			handler := p.NewHTTPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, playback.CassetteFromContext(r.Context()).Result("result", 3))
+			}))
 
-				numberGot, errGot := cassette.ResultWithError(key, f)
+			for i := 0; i < 2; i++ {
+				req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+				req.Header.Set(playback.HeaderCassetteName, "checkout")
 
-				assert.Equal(t, numberExpected, numberGot)
-				assert.Equal(t, errExpected, errGot)
-				assert.True(t, cassette.IsPlaybackSucceeded())
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, req)
+
+				body, _ := ioutil.ReadAll(w.Result().Body)
+				assert.Equal(t, "1", string(body))
+				assert.Equal(t, "checkout", w.Result().Header.Get(playback.HeaderCassetteName))
+				assert.Equal(t, string(playback.ModePlayback), w.Result().Header.Get(playback.HeaderMode))
+			}
+		})
+		t.Run("library is listed and reloaded by service", func(t *testing.T) {
+			store := playback.NewMemoryStore()
+			store.Save("checkout.yml", recorded.MarshalToYAML())
+
+			p := playback.New()
+			p.SetLibrary(store)
+			handler := p.NewHTTPServiceMiddleware(http.NotFoundHandler())
+
+			store.Save("refund.yml", other.MarshalToYAML())
+
+			req, _ := http.NewRequest("POST", "http://example.com/playback/library/reload/", nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code)
+
+			req, _ = http.NewRequest("GET", "http://example.com/playback/library/", nil)
+			w = httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, "- checkout\n- refund\n", w.Body.String())
+
+			req, _ = http.NewRequest("POST", "http://example.com/playback/library/reload/", nil)
+			w = httptest.NewRecorder()
+			playback.New().NewHTTPServiceMiddleware(http.NotFoundHandler()).ServeHTTP(w, req)
+			assert.Equal(t, http.StatusNotFound, w.Code)
+		})
+	})
+
+	t.Run("cassette root", func(t *testing.T) {
+		root, _ := ioutil.TempDir("", "playback-root")
+		defer os.RemoveAll(root)
+		outside, _ := ioutil.TempDir("", "playback-outside")
+		defer os.RemoveAll(outside)
+
+		recorded, _ := playback.New().NewCassette()
+		recorded.SetMode(playback.ModeRecord)
+		recorded.Result("result", 1)
+		ioutil.WriteFile(filepath.Join(root, "a.yml"), recorded.MarshalToYAML(), 0644)
+		ioutil.WriteFile(filepath.Join(outside, "b.yml"), recorded.MarshalToYAML(), 0644)
+		os.Symlink(outside, filepath.Join(root, "link"))
+
+		p := playback.New().SetCassetteRoot(root)
+
+		t.Run("relative paths are resolved against the root", func(t *testing.T) {
+			cassette, err := p.CassetteFromFile("a.yml")
+			assert.Nil(t, err)
+			assert.Equal(t, filepath.Join(root, "a.yml"), cassette.PathName())
+			assert.Equal(t, 1, cassette.Result("result", 2))
+
+			_, err = p.CassetteFromFile(filepath.Join(root, "a.yml"))
+			assert.Nil(t, err)
+		})
+		t.Run("symlinks are opened by the checked path", func(t *testing.T) {
+			os.Symlink(filepath.Join(root, "a.yml"), filepath.Join(root, "alias.yml"))
+			defer os.Remove(filepath.Join(root, "alias.yml"))
+
+			cassette, err := p.CassetteFromFile("alias.yml")
+			assert.Nil(t, err)
+			assert.Equal(t, filepath.Join(root, "a.yml"), cassette.PathName())
+		})
+		t.Run("paths outside the root are rejected", func(t *testing.T) {
+			for _, name := range []string{
+				"../" + filepath.Base(outside) + "/b.yml",
+				filepath.Join(outside, "b.yml"),
+				"link/b.yml",
+				"link/missing.yml",
+			} {
+				_, err := p.CassetteFromFile(name)
+				assert.Equal(t, playback.ErrOutsideCassetteRoot, err, name)
+			}
+		})
+		t.Run("new cassette files are created in the root", func(t *testing.T) {
+			p := playback.New().SetCassetteRoot(root).WithFile().SetDefaultMode(playback.ModeRecord)
+			cassette, err := p.NewCassette()
+			assert.Nil(t, err)
+			assert.Equal(t, root, filepath.Dir(cassette.PathName()))
+		})
+		t.Run("middleware can ignore file path headers", func(t *testing.T) {
+			p := playback.New().SetHeaderFileAccess(false)
+			handler := p.NewHTTPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				cassette := playback.CassetteFromContext(r.Context())
+				fmt.Fprint(w, cassette.PathType())
+			}))
+
+			for _, mode := range []playback.Mode{playback.ModePlayback, playback.ModeRecord} {
+				req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+				req.Header.Set(playback.HeaderMode, string(mode))
+				req.Header.Set(playback.HeaderCassettePathType, string(playback.PathTypeFile))
+				req.Header.Set(playback.HeaderCassettePathName, filepath.Join(root, "a.yml"))
+
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, req)
+
+				assert.Equal(t, string(playback.PathTypeNil), w.Body.String())
+				assert.Empty(t, w.Result().Header.Get(playback.HeaderCassettePathName))
+			}
+		})
+	})
+
+	t.Run("authorization", func(t *testing.T) {
+		authorizer := playback.NewBearerAuthorizer(map[string][]playback.Permission{
+			"reader":   {playback.PermissionRead},
+			"recorder": {playback.PermissionRead, playback.PermissionRecord},
+			"writer":   {playback.PermissionRecord},
+			"admin":    {playback.PermissionAdmin},
+		})
+
+		t.Run("service endpoints check permissions", func(t *testing.T) {
+			p := playback.New().SetAuthorizer(authorizer)
+			cassette, _ := p.NewCassette()
+			handler := p.NewHTTPServiceMiddleware(http.NotFoundHandler())
+
+			do := func(method, path, token string) int {
+				req, _ := http.NewRequest(method, "http://example.com"+path, nil)
+				if token != "" {
+					req.Header.Set("Authorization", "Bearer "+token)
+				}
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, req)
+				return w.Code
+			}
+
+			assert.Equal(t, http.StatusUnauthorized, do("GET", "/playback/list/", ""))
+			assert.Equal(t, http.StatusUnauthorized, do("GET", "/playback/list/", "unknown"))
+			assert.Equal(t, http.StatusOK, do("GET", "/playback/list/", "reader"))
+			assert.Equal(t, http.StatusOK, do("GET", "/playback/get/?id="+cassette.ID, "reader"))
+			assert.Equal(t, http.StatusForbidden, do("DELETE", "/playback/delete/?id="+cassette.ID, "recorder"))
+			assert.Equal(t, http.StatusOK, do("DELETE", "/playback/delete/?id="+cassette.ID, "admin"))
+			assert.Equal(t, http.StatusNotFound, do("GET", "/not-playback", ""))
+		})
+		t.Run("middleware ignores headers without permission", func(t *testing.T) {
+			p := playback.New().SetAuthorizer(authorizer)
+			handler := p.NewHTTPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, "ok")
+			}))
+
+			for token, mode := range map[string]playback.Mode{
+				"":         playback.ModeOff,
+				"reader":   playback.ModeOff,
+				"recorder": playback.ModeRecord,
+			} {
+				req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+				req.Header.Set(playback.HeaderMode, string(playback.ModeRecord))
+				if token != "" {
+					req.Header.Set("Authorization", "Bearer "+token)
+				}
+
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, req)
+
+				assert.Equal(t, string(mode), w.Result().Header.Get(playback.HeaderMode), token)
+			}
+		})
+		t.Run("existing cassettes aren't replayed by the record permission alone", func(t *testing.T) {
+			p := playback.New().SetAuthorizer(authorizer)
+			cassette, _ := p.NewCassette()
+			cassette.SetMode(playback.ModeRecord)
+			cassette.Result("a", 1)
+
+			handler := p.NewHTTPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, "ok")
+			}))
+			do := func(token string) http.Header {
+				req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+				req.Header.Set(playback.HeaderCassetteID, cassette.ID)
+				req.Header.Set(playback.HeaderMode, string(playback.ModeRecord))
+				req.Header.Set("Authorization", "Bearer "+token)
+
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, req)
+				return w.Result().Header
+			}
+
+			header := do("writer")
+			assert.Equal(t, string(playback.ModeOff), header.Get(playback.HeaderMode))
+			assert.NotEqual(t, cassette.ID, header.Get(playback.HeaderCassetteID))
+			assert.Equal(t, playback.ModeRecord, cassette.Mode())
+
+			header = do("recorder")
+			assert.Equal(t, string(playback.ModePlayback), header.Get(playback.HeaderMode))
+			assert.Equal(t, cassette.ID, header.Get(playback.HeaderCassetteID))
+		})
+		t.Run("record permission alone records new cassettes", func(t *testing.T) {
+			p := playback.New().SetAuthorizer(authorizer)
+			handler := p.NewHTTPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, "ok")
+			}))
+
+			req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+			req.Header.Set(playback.HeaderCassetteID, "missing")
+			req.Header.Set(playback.HeaderMode, string(playback.ModeRecord))
+			req.Header.Set("Authorization", "Bearer writer")
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, string(playback.ModeRecord), w.Result().Header.Get(playback.HeaderMode))
+		})
+		t.Run("client certificates grant permissions by common name", func(t *testing.T) {
+			certAuthorizer := playback.NewClientCertAuthorizer(map[string][]playback.Permission{
+				"ci": {playback.PermissionRecord},
 			})
+			creds := func(name string) playback.Credentials {
+				cert := &x509.Certificate{Subject: pkix.Name{CommonName: name}}
+				return playback.Credentials{TLS: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}}
+			}
+
+			assert.Nil(t, certAuthorizer.Authorize(creds("ci"), playback.PermissionRecord))
+			assert.Equal(t, playback.ErrForbidden, certAuthorizer.Authorize(creds("ci"), playback.PermissionAdmin))
+			assert.Equal(t, playback.ErrUnauthenticated, certAuthorizer.Authorize(creds("dev"), playback.PermissionRead))
+			assert.Equal(t, playback.ErrUnauthenticated, certAuthorizer.Authorize(playback.Credentials{}, playback.PermissionRead))
+
+			any := playback.AnyAuthorizer(authorizer, certAuthorizer)
+			assert.Nil(t, any.Authorize(creds("ci"), playback.PermissionRecord))
+			assert.Equal(t, playback.ErrForbidden, any.Authorize(creds("ci"), playback.PermissionAdmin))
+		})
+	})
+
+	t.Run("JSON API", func(t *testing.T) {
+		type apiError struct {
+			Error struct {
+				Status  int
+				Code    string
+				Message string
+			}
+		}
+		type apiRecord struct {
+			ID       uint64 `json:"id"`
+			Kind     string `json:"kind"`
+			Key      string `json:"key"`
+			Response string `json:"response"`
+		}
+
+		p := playback.New()
+		handler := p.NewHTTPServiceMiddleware(http.NotFoundHandler())
+		do := func(method, path, body string, value interface{}) *httptest.ResponseRecorder {
+			req, _ := http.NewRequest(method, "http://example.com/playback/v1"+path, strings.NewReader(body))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if value != nil {
+				assert.Nil(t, json.Unmarshal(w.Body.Bytes(), value), w.Body.String())
+			}
+			return w
+		}
+
+		cassette, _ := p.NewCassette()
+		cassette.SetMode(playback.ModeRecord)
+		cassette.Result("a", 1)
+		cassette.Result("b", 2)
+		cassette.Result("b", 3)
+		base := "/cassettes/" + cassette.ID
+
+		t.Run("records are listed with filters", func(t *testing.T) {
+			var records []apiRecord
+			w := do("GET", base+"/records", "", &records)
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+			assert.Len(t, records, 3)
+
+			do("GET", base+"/records?kind=result&key=b", "", &records)
+			assert.Len(t, records, 2)
+			assert.Equal(t, "b", records[0].Key)
+
+			do("GET", base+"/records?kind=http", "", &records)
+			assert.Len(t, records, 0)
+		})
+		t.Run("single records are appended, patched and deleted", func(t *testing.T) {
+			var rec apiRecord
+			w := do("POST", base+"/records", `{"kind":"result","key":"c","response":"4\n"}`, &rec)
+			assert.Equal(t, http.StatusCreated, w.Code)
+			assert.Equal(t, "c", rec.Key)
+			id := strconv.FormatUint(rec.ID, 10)
+
+			w = do("PATCH", base+"/records/"+id, `{"response":"5\n"}`, &rec)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "5\n", rec.Response)
+			assert.Equal(t, "c", rec.Key)
+
+			do("GET", base+"/records/"+id, "", &rec)
+			assert.Equal(t, "5\n", rec.Response)
+
+			w = do("DELETE", base+"/records/"+id, "", nil)
+			assert.Equal(t, http.StatusNoContent, w.Code)
+
+			var e apiError
+			w = do("GET", base+"/records/"+id, "", &e)
+			assert.Equal(t, http.StatusNotFound, w.Code)
+			assert.Equal(t, "record_not_found", e.Error.Code)
+		})
+		t.Run("changes of cassette directories are written right away", func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprint(w, `{"path":"`+r.URL.Path+`"}`)
+			}))
+			defer ts.Close()
+
+			recording := playback.New().WithFile().SetLayout(playback.LayoutDir).SetDefaultMode(playback.ModeRecord)
+			recorded, _ := recording.NewCassette()
+			defer os.RemoveAll(recorded.PathName())
+			httpClient := &http.Client{Transport: recording.HTTPTransport(http.DefaultTransport)}
+			for _, path := range []string{"/a", "/b"} {
+				req, _ := http.NewRequest("GET", ts.URL+path, nil)
+				res, err := httpClient.Do(req.WithContext(playback.NewContextWithCassette(req.Context(), recorded)))
+				if assert.Nil(t, err) {
+					res.Body.Close()
+				}
+			}
+			recorded.Finalize()
+
+			loaded, err := p.CassetteFromFile(recorded.PathName())
+			assert.Nil(t, err)
+			base := "/cassettes/" + loaded.ID
+			index := func() string {
+				dump, _ := ioutil.ReadFile(filepath.Join(recorded.PathName(), "index.yml"))
+				return string(dump)
+			}
+
+			var records []apiRecord
+			do("GET", base+"/records", "", &records)
+			if !assert.Len(t, records, 2) {
+				return
+			}
+
+			w := do("PATCH", base+"/records/"+strconv.FormatUint(records[0].ID, 10), `{"duration":5}`, nil)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, 2, strings.Count(index(), "response_body: bodies/"))
+			assert.Contains(t, index(), "duration: 5")
+			assert.NotContains(t, index(), `{"path":"/a"}`)
+
+			w = do("DELETE", base+"/records/"+strconv.FormatUint(records[1].ID, 10), "", nil)
+			assert.Equal(t, http.StatusNoContent, w.Code)
+			assert.Equal(t, 1, strings.Count(index(), "response_body: bodies/"))
+		})
+		t.Run("cassette state is controlled", func(t *testing.T) {
+			var status struct {
+				Mode      string
+				Locked    bool
+				Succeeded bool
+				Records   int
+			}
+			do("PUT", base+"/mode", `{"mode":"playback"}`, &status)
+			assert.Equal(t, "playback", status.Mode)
+			assert.Equal(t, playback.ModePlayback, cassette.Mode())
+
+			assert.Equal(t, 1, cassette.Result("a", 0))
+			assert.Equal(t, 2, cassette.Result("b", 0))
+			assert.Equal(t, 3, cassette.Result("b", 0))
+			do("GET", base+"/status", "", &status)
+			assert.True(t, status.Succeeded)
+			assert.Equal(t, 3, status.Records)
+
+			do("POST", base+"/rewind", "", &status)
+			assert.False(t, status.Succeeded)
+
+			do("POST", base+"/lock", "", &status)
+			assert.True(t, status.Locked)
+			var e apiError
+			w := do("POST", base+"/records", `{"kind":"result","key":"c"}`, &e)
+			assert.Equal(t, http.StatusConflict, w.Code)
+			assert.Equal(t, "cassette_locked", e.Error.Code)
+			assert.Nil(t, cassette.Error())
+
+			do("POST", base+"/unlock", "", &status)
+			assert.False(t, status.Locked)
+		})
+		t.Run("errors have a JSON body", func(t *testing.T) {
+			var e apiError
+			w := do("GET", "/cassettes/missing", "", &e)
+			assert.Equal(t, http.StatusNotFound, w.Code)
+			assert.Equal(t, http.StatusNotFound, e.Error.Status)
+			assert.Equal(t, "cassette_not_found", e.Error.Code)
+
+			w = do("PUT", base+"/mode", `{"mode":"unknown"}`, &e)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Equal(t, "invalid_mode", e.Error.Code)
+
+			w = do("POST", base+"/status", "", &e)
+			assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+			assert.Equal(t, "GET", w.Header().Get("Allow"))
+		})
+		t.Run("responses are negotiated", func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "http://example.com/playback/v1"+base+"/status", nil)
+			req.Header.Set("Accept", "application/x-yaml")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, "application/x-yaml", w.Header().Get("Content-Type"))
+			assert.Contains(t, w.Body.String(), "id: "+cassette.ID)
+
+			req.Header.Set("Accept", "text/html")
+			w = httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusNotAcceptable, w.Code)
+		})
+		t.Run("get without id is a bad request", func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "http://example.com/playback/get/", nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Empty(t, w.Body.String())
+		})
+	})
+
+	t.Run("playback report", func(t *testing.T) {
+		t.Run("report lists matched, missed and unconsumed records", func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, "ok")
+			}))
+			defer ts.Close()
+
+			p := playback.New()
+			cassette, _ := p.NewCassette()
+			cassette.SetMode(playback.ModeRecord)
+			cassette.Result("a", 1)
+			cassette.Result("b", 2)
+
+			httpClient := &http.Client{Transport: p.HTTPTransport(http.DefaultTransport)}
+			ctx := playback.NewContextWithCassette(context.Background(), cassette)
+			req, _ := http.NewRequest("GET", ts.URL+"/users?page=1", nil)
+			res, _ := httpClient.Do(req.WithContext(ctx))
+			res.Body.Close()
+
+			cassette.SetMode(playback.ModePlayback)
+			cassette.Result("a", 0)
+			cassette.Result("c", 0)
+			req, _ = http.NewRequest("GET", ts.URL+"/users?page=2", nil)
+			_, err := httpClient.Do(req.WithContext(ctx))
+			assert.NotNil(t, err)
+
+			report := cassette.Report()
+			assert.False(t, report.Succeeded)
+
+			results := report.Kinds[playback.KindResult]
+			assert.Len(t, results.Matched, 1)
+			assert.Equal(t, "a", results.Matched[0].Key)
+			assert.Len(t, results.Unconsumed, 1)
+			assert.Equal(t, "b", results.Unconsumed[0].Key)
+			assert.Len(t, results.Missed, 1)
+			assert.Equal(t, "c", results.Missed[0].Key)
+
+			calls := report.Kinds[playback.KindHTTP]
+			assert.Len(t, calls.Unconsumed, 1)
+			assert.Len(t, calls.Missed, 1)
+			assert.NotNil(t, calls.Missed[0].Nearest)
+			assert.Equal(t, calls.Unconsumed[0].ID, calls.Missed[0].Nearest.ID)
+			assert.Contains(t, calls.Missed[0].Diff, "- GET /users?page=1 HTTP/1.1")
+			assert.Contains(t, calls.Missed[0].Diff, "+ GET /users?page=2 HTTP/1.1")
+
+			cassette.Rewind()
+			assert.Empty(t, cassette.Report().Kinds[playback.KindResult].Missed)
+		})
+		t.Run("report is referenced from the response and served", func(t *testing.T) {
+			p := playback.New()
+			recorded := 1
+			handler := p.NewHTTPServiceMiddleware(p.NewHTTPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, recorded)
+			})))
+
+			req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+			req.Header.Set(playback.HeaderMode, string(playback.ModeRecord))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			cassetteID := w.Result().Header.Get(playback.HeaderCassetteID)
+
+			recorded = 2
+			req, _ = http.NewRequest("GET", "http://example.com/foo", nil)
+			req.Header.Set(playback.HeaderCassetteID, cassetteID)
+			w = httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, "false", w.Result().Header.Get(playback.HeaderSuccess))
+
+			reportPath := w.Result().Header.Get(playback.HeaderReport)
+			assert.Equal(t, "/playback/report/?id="+cassetteID, reportPath)
+
+			req, _ = http.NewRequest("GET", "http://example.com"+reportPath, nil)
+			w = httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code)
+
+			var report playback.Report
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &report))
+			assert.Equal(t, cassetteID, report.CassetteID)
+			mismatched := report.Kinds[playback.KindHTTPRequest].Mismatched
+			assert.Len(t, mismatched, 1)
+			assert.Contains(t, mismatched[0].Diff, "- 1")
+			assert.Contains(t, mismatched[0].Diff, "+ 2")
+		})
+	})
+
+	t.Run("events", func(t *testing.T) {
+		next := func(t *testing.T, events <-chan playback.Event) playback.Event {
+			select {
+			case event := <-events:
+				return event
+			case <-time.After(time.Second):
+				t.Fatal("no event")
+				return playback.Event{}
+			}
+		}
+
+		t.Run("subscribers receive cassette activity", func(t *testing.T) {
+			p := playback.New()
+			cassette, _ := p.NewCassette()
+			other, _ := p.NewCassette()
+
+			subscription := p.Subscribe(playback.EventFilter{CassetteID: cassette.ID})
+			defer subscription.Close()
+
+			other.SetMode(playback.ModeRecord)
+			cassette.SetMode(playback.ModeRecord)
+			cassette.Result("a", 1)
+			cassette.SetMode(playback.ModePlayback)
+			cassette.Result("a", 0)
+			cassette.Result("b", 0)
+
+			event := next(t, subscription.Events())
+			assert.Equal(t, playback.EventModeChanged, event.Type)
+			assert.Equal(t, cassette.ID, event.CassetteID)
+			assert.Equal(t, playback.ModeRecord, event.Mode)
+
+			event = next(t, subscription.Events())
+			assert.Equal(t, playback.EventRecordAdded, event.Type)
+			assert.Equal(t, playback.KindResult, event.Kind)
+			assert.Equal(t, "a", event.Key)
+			recordID := event.RecordID
+
+			assert.Equal(t, playback.EventModeChanged, next(t, subscription.Events()).Type)
+
+			event = next(t, subscription.Events())
+			assert.Equal(t, playback.EventRecordPlayed, event.Type)
+			assert.Equal(t, recordID, event.RecordID)
+
+			event = next(t, subscription.Events())
+			assert.Equal(t, playback.EventMiss, event.Type)
+			assert.Equal(t, "b", event.Key)
+		})
+		t.Run("API changes of records are events", func(t *testing.T) {
+			p := playback.New()
+			cassette, _ := p.NewCassette()
+			cassette.SetMode(playback.ModeRecord)
+			cassette.Result("a", 1)
+
+			subscription := p.Subscribe(playback.EventFilter{CassetteID: cassette.ID})
+			defer subscription.Close()
+
+			handler := p.NewHTTPServiceMiddleware(http.NotFoundHandler())
+			do := func(method, body string) {
+				req, _ := http.NewRequest(method, "http://example.com/playback/v1/cassettes/"+cassette.ID+"/records/1", strings.NewReader(body))
+				handler.ServeHTTP(httptest.NewRecorder(), req)
+			}
+
+			do("PATCH", `{"response":"2\n"}`)
+			event := next(t, subscription.Events())
+			assert.Equal(t, playback.EventRecordUpdated, event.Type)
+			assert.Equal(t, uint64(1), event.RecordID)
+
+			do("DELETE", "")
+			event = next(t, subscription.Events())
+			assert.Equal(t, playback.EventRecordDeleted, event.Type)
+			assert.Equal(t, "a", event.Key)
+		})
+		t.Run("subscribers filter by kind", func(t *testing.T) {
+			p := playback.New()
+			subscription := p.Subscribe(playback.EventFilter{Kinds: []playback.RecordKind{playback.KindHTTP}})
+			defer subscription.Close()
+
+			cassette, _ := p.NewCassette()
+			cassette.SetMode(playback.ModeRecord)
+			cassette.Result("a", 1)
+
+			assert.Equal(t, playback.EventModeChanged, next(t, subscription.Events()).Type)
+			select {
+			case event := <-subscription.Events():
+				t.Fatalf("unexpected event %v", event)
+			default:
+			}
+
+			subscription.Close()
+			_, ok := <-subscription.Events()
+			assert.False(t, ok)
+		})
+		t.Run("events are streamed by service", func(t *testing.T) {
+			p := playback.New()
+			cassette, _ := p.NewCassette()
+			ts := httptest.NewServer(p.NewHTTPServiceMiddleware(http.NotFoundHandler()))
+			defer ts.Close()
+
+			res, err := http.Get(ts.URL + "/playback/events/?id=" + cassette.ID + "&kind=result")
+			assert.Nil(t, err)
+			defer res.Body.Close()
+			assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+			cassette.SetMode(playback.ModeRecord)
+			cassette.Result("a", 1)
+
+			reader := bufio.NewReader(res.Body)
+			var lines []string
+			for len(lines) < 4 {
+				line, err := reader.ReadString('\n')
+				assert.Nil(t, err)
+				if line = strings.TrimSpace(line); line != "" {
+					lines = append(lines, line)
+				}
+			}
+
+			assert.Equal(t, "event: mode_changed", lines[0])
+			assert.Equal(t, "event: record_added", lines[2])
+
+			var event playback.Event
+			assert.Nil(t, json.Unmarshal([]byte(strings.TrimPrefix(lines[3], "data: ")), &event))
+			assert.Equal(t, cassette.ID, event.CassetteID)
+			assert.Equal(t, "a", event.Key)
 		})
 	})
 
@@ -664,25 +3089,31 @@ func TestCassete(t *testing.T) {
 					"  id: 1\n" +
 					"  requestmeta: curl -X 'GET' '" + ts.URL + "'\n" +
 					"  request: " + `"GET / HTTP/1.1\r\nHost: ` + strings.TrimPrefix(ts.URL, "http://") + `\r\nUser-Agent: Go-http-client/1.1\r\nAccept-Encoding:` + "\n" + `    gzip\r\n\r\n"` + "\n"
-				contentsExpected := "" +
+				contentsExpected := cassetteHeader +
 					contentsCommon +
 					"  responsemeta: \"\"\n" +
 					"  response: \"\"\n" +
 					"  err: null\n" +
 					"  panic: null\n" +
+					"  created_at: <created_at>\n" +
 
 					contentsCommon +
 					"  responsemeta: \"\"\n" +
 					`  response: "HTTP/1.1 200 OK\r\nContent-Length: 9\r\nContent-Type: text/plain; charset=utf-8\r\nDate:` + "\n" +
 					`    ` + response.Header.Get("Date") + `\r\nHi: ` + strconv.Itoa(counter) + `\r\n\r\n` + strings.TrimSuffix(string(body), "\n") + `\n"` + "\n" +
 					"  err: null\n" +
-					"  panic: null\n"
+					"  panic: null\n" +
+					"  duration: <duration>\n" +
+					"  created_at: <created_at>\n"
 
 				contentsGot, err := ioutil.ReadFile(cassette.PathName())
 				if err != nil {
 					t.Fatal(err)
 				}
 
+				contentsGot = regexp.MustCompile(`duration: \S+`).ReplaceAll(contentsGot, []byte("duration: <duration>"))
+				contentsGot = normalizeCreatedAt(contentsGot)
+
 				assert.Equal(t, contentsExpected, string(contentsGot))
 			})
 
@@ -793,7 +3224,7 @@ func TestCassete(t *testing.T) {
 					if test.serverFails {
 						w.WriteHeader(http.StatusInternalServerError)
 					}
-					fmt.Fprintf(w, serverResponse)
+					fmt.Fprint(w, serverResponse)
 				}))
 				defer ts.Close()
 
@@ -1176,13 +3607,20 @@ func TestCassete(t *testing.T) {
 					resp := w.Result()
 					body, _ := ioutil.ReadAll(resp.Body)
 
-					var got interface{}
+					var got struct {
+						playback.CassetteHeader `yaml:",inline"`
+						Records                 []interface{}
+					}
 					yaml.Unmarshal(body, &got)
 
-					var expected interface{}
+					var expected struct {
+						playback.CassetteHeader `yaml:",inline"`
+						Records                 []interface{}
+					}
 					yaml.Unmarshal(cassette.MarshalToYAML(), &expected)
 
-					assert.ElementsMatch(t, expected, got)
+					assert.Equal(t, expected.CassetteHeader, got.CassetteHeader)
+					assert.ElementsMatch(t, expected.Records, got.Records)
 				})
 			})
 			t.Run("Delete cassette from server using HTTP method", func(t *testing.T) {
@@ -1791,6 +4229,66 @@ func removeFilename(t *testing.T, filename string) {
 	}
 }
 
+const cassetteHeader = "" +
+	"version: 2\n" +
+	"library: " + playback.LibraryVersion + "\n" +
+	"created_at: <created_at>\n" +
+	"records:\n"
+
+const openAPIDocument = `
+openapi: 3.0.3
+servers:
+  - url: http://api.example.com/v1
+paths:
+  /users/{id}:
+    parameters:
+      - name: id
+        in: path
+        required: true
+        schema: {type: integer}
+    get:
+      parameters:
+        - name: verbose
+          in: query
+          schema: {type: boolean}
+      responses:
+        "200":
+          description: user
+          content:
+            application/json:
+              schema: {$ref: "#/components/schemas/User"}
+        "404":
+          $ref: "#/components/responses/NotFound"
+  /users/me:
+    get:
+      responses:
+        "200":
+          description: current user
+          content:
+            application/json:
+              schema: {$ref: "#/components/schemas/User"}
+              example: {id: 7, name: me}
+components:
+  schemas:
+    User:
+      type: object
+      required: [id, name]
+      additionalProperties: false
+      properties:
+        id: {type: integer, example: 42}
+        name: {type: string, minLength: 1}
+        friends:
+          type: array
+          items: {$ref: "#/components/schemas/User"}
+  responses:
+    NotFound:
+      description: not found
+`
+
+func normalizeCreatedAt(contents []byte) []byte {
+	return regexp.MustCompile(`created_at: \S+`).ReplaceAll(contents, []byte("created_at: <created_at>"))
+}
+
 func keyOfRequest(req *http.Request) string {
 	requestDump, _ := httputil.DumpRequestOut(req, true)
 	key := req.URL.Path + "?" + calcMD5(requestDump)
@@ -1837,3 +4335,14 @@ type variableLogger struct {
 func (l *variableLogger) Debugf(format string, args ...interface{}) {
 	*l.log += fmt.Sprintf(format, args...)
 }
+
+// countingStore counts the saves of the store it wraps.
+type countingStore struct {
+	playback.Store
+	saves int
+}
+
+func (s *countingStore) Save(name string, dump []byte) error {
+	s.saves++
+	return s.Store.Save(name, dump)
+}
@@ -0,0 +1,124 @@
+package playback
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// vcrCassette is a go-vcr cassette of version 1 or 2.
+type vcrCassette struct {
+	Version      int              `yaml:"version"`
+	Interactions []vcrInteraction `yaml:"interactions"`
+}
+
+type vcrInteraction struct {
+	Request struct {
+		Body    string              `yaml:"body"`
+		Headers map[string][]string `yaml:"headers"`
+		URL     string              `yaml:"url"`
+		Method  string              `yaml:"method"`
+	} `yaml:"request"`
+	Response struct {
+		Body     string              `yaml:"body"`
+		Headers  map[string][]string `yaml:"headers"`
+		Status   string              `yaml:"status"`
+		Code     int                 `yaml:"code"`
+		Duration string              `yaml:"duration"`
+	} `yaml:"response"`
+}
+
+// AddFromGoVCR converts the interactions of a go-vcr cassette into http records.
+// Besides the exact request key, the records match requests by method and full URL like go-vcr does by default.
+func (c *Cassette) AddFromGoVCR(dump []byte) error {
+	var vcr vcrCassette
+	err := yaml.Unmarshal(dump, &vcr)
+	if err != nil {
+		return err
+	}
+
+	for _, interaction := range vcr.Interactions {
+		rec, err := c.recordFromVCRInteraction(interaction)
+		if err != nil {
+			return err
+		}
+
+		err = c.Add(rec)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Cassette) recordFromVCRInteraction(interaction vcrInteraction) (*record, error) {
+	request := interaction.Request
+	req, err := http.NewRequest(request.Method, request.URL, strings.NewReader(request.Body))
+	if err != nil {
+		return nil, err
+	}
+	if request.Body == "" {
+		req.Body, req.ContentLength = nil, 0
+	}
+	for name, values := range request.Headers {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+
+	rec := (&HTTPRecorder{cassette: c}).newRecord(req)
+	rec.Match = &HTTPMatch{
+		Method: req.Method,
+		URL:    req.URL.String(),
+	}
+
+	response := interaction.Response
+	status := response.Status
+	if !strings.HasPrefix(status, strconv.Itoa(response.Code)) {
+		status = strconv.Itoa(response.Code) + " " + status
+	}
+	res := &http.Response{
+		Status:        status,
+		StatusCode:    response.Code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header, len(response.Headers)),
+		Body:          ioutil.NopCloser(bytes.NewReader([]byte(response.Body))),
+		ContentLength: int64(len(response.Body)),
+		Request:       req,
+	}
+	for name, values := range response.Headers {
+		if strings.EqualFold(name, "Content-Length") || strings.EqualFold(name, "Transfer-Encoding") {
+			continue
+		}
+		for _, value := range values {
+			res.Header.Add(name, value)
+		}
+	}
+
+	rec.Response = httpDumpResponse(res)
+	rec.Duration, _ = time.ParseDuration(response.Duration)
+
+	return rec, nil
+}
+
+// CassetteFromGoVCR converts a go-vcr cassette, see Cassette.AddFromGoVCR.
+func (p *Playback) CassetteFromGoVCR(dump []byte) (*Cassette, error) {
+	c := newCassette(p)
+	c.SetMode(ModePlayback)
+
+	err := c.AddFromGoVCR(dump)
+	if err != nil {
+		p.Delete(c.ID)
+		return nil, err
+	}
+
+	return c, nil
+}
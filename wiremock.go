@@ -0,0 +1,355 @@
+package playback
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const wireMockKeyPrefix = "wiremock:"
+
+// WireMockMappings is a WireMock mappings file.
+type WireMockMappings struct {
+	Mappings []WireMockMapping `json:"mappings"`
+}
+
+type WireMockMapping struct {
+	ID       string           `json:"id,omitempty"`
+	Name     string           `json:"name,omitempty"`
+	Priority int              `json:"priority,omitempty"`
+	Request  WireMockRequest  `json:"request"`
+	Response WireMockResponse `json:"response"`
+}
+
+type WireMockRequest struct {
+	Method          string                   `json:"method,omitempty"`
+	URL             string                   `json:"url,omitempty"`
+	URLPath         string                   `json:"urlPath,omitempty"`
+	URLPattern      string                   `json:"urlPattern,omitempty"`
+	URLPathPattern  string                   `json:"urlPathPattern,omitempty"`
+	Headers         map[string]WireMockMatch `json:"headers,omitempty"`
+	QueryParameters map[string]WireMockMatch `json:"queryParameters,omitempty"`
+	BodyPatterns    []WireMockMatch          `json:"bodyPatterns,omitempty"`
+}
+
+type WireMockMatch struct {
+	EqualTo         string          `json:"equalTo,omitempty"`
+	CaseInsensitive bool            `json:"caseInsensitive,omitempty"`
+	Contains        string          `json:"contains,omitempty"`
+	Matches         string          `json:"matches,omitempty"`
+	DoesNotMatch    string          `json:"doesNotMatch,omitempty"`
+	EqualToJSON     json.RawMessage `json:"equalToJson,omitempty"`
+	Absent          bool            `json:"absent,omitempty"`
+}
+
+type WireMockResponse struct {
+	Status                 int                    `json:"status"`
+	StatusMessage          string                 `json:"statusMessage,omitempty"`
+	Headers                map[string]interface{} `json:"headers,omitempty"`
+	Body                   string                 `json:"body,omitempty"`
+	JSONBody               json.RawMessage        `json:"jsonBody,omitempty"`
+	Base64Body             string                 `json:"base64Body,omitempty"`
+	FixedDelayMilliseconds int                    `json:"fixedDelayMilliseconds,omitempty"`
+}
+
+// AddFromWireMock converts WireMock stub mappings into http records matched by the mapping request matchers.
+// The dump is either a mappings file or a single mapping. Stubs answer any number of requests, so the records are sticky.
+func (c *Cassette) AddFromWireMock(dump []byte) error {
+	var mappings WireMockMappings
+	err := json.Unmarshal(dump, &mappings)
+	if err != nil {
+		return err
+	}
+	if mappings.Mappings == nil {
+		var mapping WireMockMapping
+		err = json.Unmarshal(dump, &mapping)
+		if err != nil {
+			return err
+		}
+		mappings.Mappings = []WireMockMapping{mapping}
+	}
+
+	for i, mapping := range mappings.Mappings {
+		rec, err := recordFromWireMockMapping(mapping, i)
+		if err != nil {
+			return err
+		}
+
+		err = c.Add(rec)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func recordFromWireMockMapping(mapping WireMockMapping, i int) (*record, error) {
+	key := mapping.ID
+	if key == "" {
+		key = strconv.Itoa(i + 1)
+	}
+
+	request := mapping.Request
+	match := &HTTPMatch{
+		Method:         request.Method,
+		URL:            request.URL,
+		URLPath:        request.URLPath,
+		URLPattern:     request.URLPattern,
+		URLPathPattern: request.URLPathPattern,
+		Headers:        wireMockValueMatches(request.Headers),
+		Query:          wireMockValueMatches(request.QueryParameters),
+		Priority:       mapping.Priority,
+	}
+	for _, pattern := range request.BodyPatterns {
+		match.Body = append(match.Body, pattern.valueMatch())
+	}
+
+	res, err := mapping.Response.httpResponse()
+	if err != nil {
+		return nil, err
+	}
+
+	return &record{
+		Kind:        KindHTTP,
+		Key:         wireMockKeyPrefix + key,
+		RequestMeta: mapping.Name,
+		Response:    httpDumpResponse(res),
+		Match:       match,
+		Replay:      ReplayPolicy{Mode: ReplaySticky},
+		Duration:    time.Duration(mapping.Response.FixedDelayMilliseconds) * time.Millisecond,
+	}, nil
+}
+
+func wireMockValueMatches(matches map[string]WireMockMatch) map[string]ValueMatch {
+	if len(matches) == 0 {
+		return nil
+	}
+
+	values := make(map[string]ValueMatch, len(matches))
+	for name, match := range matches {
+		values[name] = match.valueMatch()
+	}
+
+	return values
+}
+
+func (m WireMockMatch) valueMatch() ValueMatch {
+	value := ValueMatch{
+		EqualTo:         m.EqualTo,
+		CaseInsensitive: m.CaseInsensitive,
+		Contains:        m.Contains,
+		Matches:         m.Matches,
+		DoesNotMatch:    m.DoesNotMatch,
+		Absent:          m.Absent,
+	}
+
+	// equalToJson is either a JSON document or a string with one
+	var document string
+	if json.Unmarshal(m.EqualToJSON, &document) == nil {
+		value.EqualToJSON = document
+	} else if len(m.EqualToJSON) > 0 {
+		value.EqualToJSON = string(m.EqualToJSON)
+	}
+
+	return value
+}
+
+func newWireMockMatch(value ValueMatch) WireMockMatch {
+	match := WireMockMatch{
+		EqualTo:         value.EqualTo,
+		CaseInsensitive: value.CaseInsensitive,
+		Contains:        value.Contains,
+		Matches:         value.Matches,
+		DoesNotMatch:    value.DoesNotMatch,
+		Absent:          value.Absent,
+	}
+	if value.EqualToJSON != "" {
+		match.EqualToJSON = json.RawMessage(value.EqualToJSON)
+	}
+
+	return match
+}
+
+func (r WireMockResponse) httpResponse() (*http.Response, error) {
+	body := []byte(r.Body)
+	switch {
+	case len(r.JSONBody) > 0:
+		body = r.JSONBody
+	case r.Base64Body != "":
+		var err error
+		body, err = base64.StdEncoding.DecodeString(r.Base64Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	status := r.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	statusText := r.StatusMessage
+	if statusText == "" {
+		statusText = http.StatusText(status)
+	}
+
+	res := &http.Response{
+		Status:        strconv.Itoa(status) + " " + statusText,
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header, len(r.Headers)),
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+	for name, value := range r.Headers {
+		switch value := value.(type) {
+		case []interface{}:
+			for _, item := range value {
+				res.Header.Add(name, fmt.Sprint(item))
+			}
+		default:
+			res.Header.Add(name, fmt.Sprint(value))
+		}
+	}
+	if len(r.JSONBody) > 0 && res.Header.Get("Content-Type") == "" {
+		res.Header.Set("Content-Type", "application/json")
+	}
+
+	return res, nil
+}
+
+// MarshalToWireMock exports the http records of the cassette as WireMock stub mappings.
+// Records without a match rule are matched by method, URL and body.
+func (c *Cassette) MarshalToWireMock() ([]byte, error) {
+	c.mu.RLock()
+	records := sortRecords(c.resolved(c.records()), false)
+	c.mu.RUnlock()
+
+	mappings := WireMockMappings{Mappings: make([]WireMockMapping, 0, len(records))}
+	for _, rec := range records {
+		if rec.Kind != KindHTTP || rec.Response == "" {
+			continue
+		}
+
+		mapping, err := wireMockMappingFromRecord(rec)
+		if err != nil {
+			return nil, err
+		}
+		mappings.Mappings = append(mappings.Mappings, mapping)
+	}
+
+	return json.MarshalIndent(mappings, "", "  ")
+}
+
+func wireMockMappingFromRecord(rec *record) (WireMockMapping, error) {
+	mapping := WireMockMapping{
+		Name: strings.TrimPrefix(rec.Key, wireMockKeyPrefix),
+	}
+
+	var req *http.Request
+	if rec.Match != nil {
+		mapping.Priority = rec.Match.Priority
+		mapping.Request = WireMockRequest{
+			Method:         rec.Match.Method,
+			URL:            rec.Match.URL,
+			URLPath:        rec.Match.URLPath,
+			URLPattern:     rec.Match.URLPattern,
+			URLPathPattern: rec.Match.URLPathPattern,
+		}
+		if len(rec.Match.Headers) > 0 {
+			mapping.Request.Headers = make(map[string]WireMockMatch, len(rec.Match.Headers))
+			for name, value := range rec.Match.Headers {
+				mapping.Request.Headers[name] = newWireMockMatch(value)
+			}
+		}
+		if len(rec.Match.Query) > 0 {
+			mapping.Request.QueryParameters = make(map[string]WireMockMatch, len(rec.Match.Query))
+			for name, value := range rec.Match.Query {
+				mapping.Request.QueryParameters[name] = newWireMockMatch(value)
+			}
+		}
+		for _, value := range rec.Match.Body {
+			mapping.Request.BodyPatterns = append(mapping.Request.BodyPatterns, newWireMockMatch(value))
+		}
+	} else {
+		var err error
+		req, err = httpReadRequest(rec.Request)
+		if err != nil {
+			return mapping, err
+		}
+
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return mapping, err
+		}
+
+		mapping.Request = WireMockRequest{
+			Method: req.Method,
+			URL:    req.URL.RequestURI(),
+		}
+		if len(body) > 0 {
+			pattern := WireMockMatch{EqualTo: string(body)}
+			if json.Valid(body) {
+				pattern = WireMockMatch{EqualToJSON: json.RawMessage(body)}
+			}
+			mapping.Request.BodyPatterns = []WireMockMatch{pattern}
+		}
+	}
+
+	res, err := httpReadResponse(rec.Response, req)
+	if err != nil {
+		return mapping, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return mapping, err
+	}
+
+	mapping.Response = WireMockResponse{
+		Status:        res.StatusCode,
+		StatusMessage: strings.TrimPrefix(res.Status, strconv.Itoa(res.StatusCode)+" "),
+		Headers:       make(map[string]interface{}, len(res.Header)),
+	}
+	for name, values := range res.Header {
+		if name == "Content-Length" || name == "Date" {
+			continue
+		}
+		if len(values) == 1 {
+			mapping.Response.Headers[name] = values[0]
+		} else {
+			mapping.Response.Headers[name] = values
+		}
+	}
+	if utf8.Valid(body) {
+		mapping.Response.Body = string(body)
+	} else {
+		mapping.Response.Base64Body = base64.StdEncoding.EncodeToString(body)
+	}
+
+	return mapping, nil
+}
+
+// CassetteFromWireMock converts WireMock stub mappings, see Cassette.AddFromWireMock.
+func (p *Playback) CassetteFromWireMock(dump []byte) (*Cassette, error) {
+	c := newCassette(p)
+	c.SetMode(ModePlayback)
+
+	err := c.AddFromWireMock(dump)
+	if err != nil {
+		p.Delete(c.ID)
+		return nil, err
+	}
+
+	return c, nil
+}
@@ -16,8 +16,11 @@ type Writer interface {
 type PathType string
 
 const (
-	PathTypeNil  = PathType("")
-	PathTypeFile = PathType("file")
+	PathTypeNil    = PathType("")
+	PathTypeFile   = PathType("file")
+	PathTypeMemory = PathType("memory")
+	PathTypeDir    = PathType("dir")
+	PathTypeFS     = PathType("fs")
 )
 
 type file struct {